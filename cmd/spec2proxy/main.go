@@ -22,6 +22,7 @@ import (
 	"github.com/micovery/spec2proxy/pkg/generator"
 	"github.com/micovery/spec2proxy/pkg/parser"
 	"github.com/micovery/spec2proxy/pkg/plugins"
+	"github.com/micovery/spec2proxy/pkg/transformer"
 	v2 "github.com/micovery/spec2proxy/pkg/transformer/v2"
 	"github.com/micovery/spec2proxy/pkg/transformer/v3"
 	"github.com/micovery/spec2proxy/pkg/utils"
@@ -29,12 +30,14 @@ import (
 	"github.com/pb33f/libopenapi"
 	v2high "github.com/pb33f/libopenapi/datamodel/high/v2"
 	v3high "github.com/pb33f/libopenapi/datamodel/high/v3"
+	"strings"
 )
 
 func main() {
 	var specFile string
 	var outputDir string
 	var pluginsList string
+	var googleScopes string
 
 	var errs []error
 	var err error
@@ -42,11 +45,41 @@ func main() {
 	var specModelV3 *libopenapi.DocumentModel[v3high.Document]
 	var apiModel *v1.APIProxy
 
+	options := transformer.NewOptions()
+
 	flag.StringVar(&specFile, "oas", "", "path to OpenAPI spec file. e.g. \"./petstore.yaml\"")
 	flag.StringVar(&outputDir, "out", "", "output directory. e.g \"./hello-world\"")
 	flag.StringVar(&pluginsList, "plugins", "", "list of plugins. e.g. \"plugin1,plugin2,etc\"")
+	flag.StringVar(&options.PathVariablesPrefix, "path-vars-prefix", options.PathVariablesPrefix, "prefix for flow variables extracted from path parameters. e.g. \"oas.path\" (empty to disable)")
+	flag.BoolVar(&options.MethodNotAllowed, "method-not-allowed", options.MethodNotAllowed, "respond HTTP 405 when a path is requested with an undeclared verb")
+	flag.BoolVar(&options.AutoOptions, "auto-options", options.AutoOptions, "respond to OPTIONS requests with the verbs declared for the path")
+	flag.BoolVar(&options.HeadToGet, "head-to-get", options.HeadToGet, "route HEAD requests to the GET operation of the same path")
+	flag.StringVar(&options.TrailingSlash, "trailing-slash", options.TrailingSlash, "trailing slash handling. e.g. \"strict\", \"lenient\", or \"redirect\"")
+	flag.BoolVar(&options.SplitByTag, "split-by-tag", options.SplitByTag, "generate a separate proxy endpoint for the operations of each tag")
+	flag.BoolVar(&options.Security, "security", options.Security, "generate policies that enforce the spec security requirements")
+	flag.BoolVar(&options.ThreatProtection, "threat-protection", options.ThreatProtection, "add JSON and XML threat protection policies derived from the request body schemas")
+	flag.BoolVar(&options.ParameterValidation, "parameter-validation", options.ParameterValidation, "reject query and header parameters that do not match their pattern or enum")
+	flag.BoolVar(&options.MediaTypeValidation, "media-type-validation", options.MediaTypeValidation, "reject requests with a Content-Type (415) or Accept (406) header that does not match the media types in the spec")
+	flag.BoolVar(&options.InjectionProtection, "injection-protection", options.InjectionProtection, "reject requests containing common injection patterns")
+	flag.BoolVar(&options.ParameterDefaults, "parameter-defaults", options.ParameterDefaults, "add the spec default values of missing query and header parameters")
+	flag.BoolVar(&options.EnforceSunset, "enforce-sunset", options.EnforceSunset, "respond 410 to deprecated operations after the sunset date from their x-sunset extension")
+	flag.BoolVar(&options.OASResource, "oas-resource", options.OASResource, "bundle a dereferenced copy of the spec with the API proxy")
+	flag.BoolVar(&options.OASValidation, "oas-validation", options.OASValidation, "validate requests against the bundled spec with an OASValidation policy")
+	flag.BoolVar(&options.Mock, "mock", options.Mock, "generate a proxy without target, that responds with the examples from the spec")
+	flag.BoolVar(&options.APIProducts, "api-products", options.APIProducts, "generate API product definitions (products.json) for the operations of the spec")
+	flag.BoolVar(&options.InsecureTargetTLS, "insecure-target-tls", options.InsecureTargetTLS, "do not validate the certificate of HTTPS targets")
+	flag.StringVar(&options.GoogleAuth, "google-auth", options.GoogleAuth, "authenticate target requests with a Google token. e.g. \"id-token\", or \"access-token\"")
+	flag.StringVar(&options.GoogleAudience, "google-audience", options.GoogleAudience, "audience of Google ID tokens (defaults to the target URL)")
+	flag.StringVar(&googleScopes, "google-scopes", strings.Join(options.GoogleScopes, ","), "list of scopes for Google access tokens. e.g. \"scope1,scope2,etc\"")
 	flag.Parse()
 
+	options.GoogleScopes = nil
+	for _, scope := range strings.Split(googleScopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			options.GoogleScopes = append(options.GoogleScopes, scope)
+		}
+	}
+
 	if specFile == "" {
 		utils.RequireParamAndExit("oas")
 	}
@@ -55,6 +88,10 @@ func main() {
 		utils.RequireParamAndExit("out")
 	}
 
+	if err = options.Validate(); err != nil {
+		utils.PrintErrorWithStackAndExit(err)
+	}
+
 	var spec libopenapi.Document
 	if spec, err = parser.Parse(specFile); err != nil {
 		fmt.Println(err)
@@ -74,7 +111,7 @@ func main() {
 			utils.PrintErrorWithStackAndExit(err)
 		}
 
-		if apiModel, err = v2.Transform(specModelV2); err != nil {
+		if apiModel, err = v2.Transform(specModelV2, options); err != nil {
 			utils.PrintErrorWithStackAndExit(err)
 		}
 	} else if specVersion >= 3 {
@@ -89,7 +126,7 @@ func main() {
 			utils.PrintErrorWithStackAndExit(err)
 		}
 
-		if apiModel, err = v3.Transform(specModelV3); err != nil {
+		if apiModel, err = v3.Transform(specModelV3, options); err != nil {
 			utils.PrintErrorWithStackAndExit(err)
 		}
 	} else {
@@ -103,6 +140,11 @@ func main() {
 		utils.PrintErrorWithStackAndExit(err)
 	}
 
+	// the API products are generated from the final list of operations, after the plugins have processed the model
+	if err = transformer.AddAPIProducts(apiModel, options); err != nil {
+		utils.PrintErrorWithStackAndExit(err)
+	}
+
 	if err = generator.Generate(apiModel, outputDir); err != nil {
 		utils.PrintErrorWithStackAndExit(err)
 	}
@@ -27,6 +27,7 @@ type Extension struct {
 type Resource struct {
 	ResourceType string
 	ResourceFile string
+	Content      []byte
 }
 
 type ProxyEndpoint struct {
@@ -48,6 +49,8 @@ type SSLInfo struct {
 	KeyStore               string
 	KeyAlias               string
 	TrustStore             string
+	CommonName             string
+	WildcardMatch          bool
 	IgnoreValidationErrors bool
 }
 
@@ -70,11 +73,28 @@ type LoadBalancer struct {
 	Algorithm string
 	Servers   []*TargetServer
 }
+type GoogleIDToken struct {
+	Audience     string
+	UseTargetUrl bool
+	IncludeEmail bool
+}
+
+type GoogleAccessToken struct {
+	Scopes            []string
+	LifetimeInSeconds int
+}
+
+type Authentication struct {
+	GoogleIDToken     *GoogleIDToken
+	GoogleAccessToken *GoogleAccessToken
+}
+
 type HTTPTargetConnection struct {
-	URL          string
-	LoadBalancer LoadBalancer
-	SSLInfo      SSLInfo
-	Properties   []*Property
+	URL            string
+	LoadBalancer   LoadBalancer
+	SSLInfo        SSLInfo
+	Authentication *Authentication
+	Properties     []*Property
 }
 
 type RouteRule struct {
@@ -101,6 +121,45 @@ type ConditionalFlow struct {
 	Response            []*Step `json:"Response" yaml:"Response"`
 	Extensions          map[string]*Extension
 	SecurityRequirement []*base.SecurityRequirement
+	Path                string
+	Verb                string
+	Tags                []string
+	Deprecated          bool
+	Parameters          []*Parameter
+	RequestBody         *RequestBody
+	Responses           []*Response
+}
+
+type Example struct {
+	Name  string
+	Value *yaml.Node
+}
+
+type MediaType struct {
+	ContentType string
+	Schema      *base.Schema
+	Example     *yaml.Node
+	Examples    []*Example
+}
+
+type Response struct {
+	StatusCode  string
+	Description string
+	Content     []*MediaType
+}
+
+type RequestBody struct {
+	Required bool
+	Content  []*MediaType
+}
+
+type Parameter struct {
+	Name          string
+	In            string
+	Required      bool
+	AllowReserved bool
+	Schema        *base.Schema
+	Extensions    map[string]*Extension
 }
 
 type UnconditionalFlow struct {
@@ -118,6 +177,19 @@ type Step struct {
 	} `json:"Step" yaml:"Step"`
 }
 
+type SecurityScheme struct {
+	Name             string
+	Type             string
+	Description      string
+	In               string
+	ParamName        string
+	Scheme           string
+	BearerFormat     string
+	OpenIdConnectUrl string
+	Scopes           []string
+	Extensions       map[string]*Extension
+}
+
 type APIProxy struct {
 	Name            string
 	Description     string
@@ -128,7 +200,9 @@ type APIProxy struct {
 	ProxyEndpoints  []*ProxyEndpoint
 	TargetEndpoints []*TargetEndpoint
 	Resources       []*Resource
+	APIProducts     []*APIProduct
 	Extensions      map[string]*Extension
+	SecuritySchemes map[string]*SecurityScheme
 }
 
 func NewStep(name string, condition string) *Step {
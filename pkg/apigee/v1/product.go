@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+// The API product types follow the shape of the Apigee management API (and apigeecli) JSON
+
+type Attribute struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Operation struct {
+	Resource string   `json:"resource"`
+	Methods  []string `json:"methods"`
+}
+
+type OperationConfig struct {
+	APISource  string       `json:"apiSource"`
+	Operations []*Operation `json:"operations"`
+}
+
+type OperationGroup struct {
+	OperationConfigs    []*OperationConfig `json:"operationConfigs"`
+	OperationConfigType string             `json:"operationConfigType"`
+}
+
+type APIProduct struct {
+	Name           string          `json:"name"`
+	DisplayName    string          `json:"displayName"`
+	Description    string          `json:"description,omitempty"`
+	ApprovalType   string          `json:"approvalType"`
+	Attributes     []*Attribute    `json:"attributes,omitempty"`
+	Environments   []string        `json:"environments,omitempty"`
+	Scopes         []string        `json:"scopes,omitempty"`
+	OperationGroup *OperationGroup `json:"operationGroup"`
+}
+
+// AddOperation adds the method for the resource to the operation group of the given API proxy
+func (p *APIProduct) AddOperation(apiSource string, resource string, method string) {
+	var config *OperationConfig
+	for _, c := range p.OperationGroup.OperationConfigs {
+		if c.APISource == apiSource {
+			config = c
+			break
+		}
+	}
+
+	if config == nil {
+		config = &OperationConfig{APISource: apiSource}
+		p.OperationGroup.OperationConfigs = append(p.OperationGroup.OperationConfigs, config)
+	}
+
+	for _, operation := range config.Operations {
+		if operation.Resource != resource {
+			continue
+		}
+		for _, m := range operation.Methods {
+			if m == method {
+				return
+			}
+		}
+		operation.Methods = append(operation.Methods, method)
+		return
+	}
+
+	config.Operations = append(config.Operations, &Operation{Resource: resource, Methods: []string{method}})
+}
+
+func NewAPIProduct(name string, displayName string) *APIProduct {
+	return &APIProduct{
+		Name:         name,
+		DisplayName:  displayName,
+		ApprovalType: "auto",
+		OperationGroup: &OperationGroup{
+			OperationConfigs:    []*OperationConfig{},
+			OperationConfigType: "proxy",
+		},
+	}
+}
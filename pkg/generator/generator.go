@@ -17,6 +17,7 @@ package generator
 import (
 	"bytes"
 	_ "embed"
+	"encoding/json"
 	"fmt"
 	"github.com/go-errors/errors"
 	"github.com/micovery/spec2proxy/pkg/apigee/v1"
@@ -87,6 +88,30 @@ func Generate(apiProxy *v1.APIProxy, outputDir string) error {
 		}
 	}
 
+	//generate resource files
+	for _, resource := range apiProxy.Resources {
+		resourceDirPath := filepath.Join(apiProxyDirPath, "resources", resource.ResourceType)
+		if err = os.MkdirAll(resourceDirPath, os.ModePerm); err != nil {
+			return errors.New(err)
+		}
+
+		if err = os.WriteFile(filepath.Join(resourceDirPath, resource.ResourceFile), resource.Content, os.ModePerm); err != nil {
+			return errors.New(err)
+		}
+	}
+
+	//generate API products file
+	if len(apiProxy.APIProducts) > 0 {
+		var productsBytes []byte
+		if productsBytes, err = json.MarshalIndent(apiProxy.APIProducts, "", "  "); err != nil {
+			return errors.New(err)
+		}
+
+		if err = os.WriteFile(filepath.Join(outputDir, "products.json"), productsBytes, os.ModePerm); err != nil {
+			return errors.New(err)
+		}
+	}
+
 	return nil
 }
 
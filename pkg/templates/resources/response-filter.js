@@ -0,0 +1,90 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Prunes the JSON response body down to the properties declared in the response schema of the operation.
+// The "filters" property of the policy maps the response status codes (e.g. 200, 2XX, or default) to the filters
+// generated from the schemas. A missing filter keeps the value as-is. The "definitions" property has the filters of
+// recursive schemas, which are referenced by name (e.g. {"$ref": "#/components/schemas/Node"}) from the other filters.
+
+var filters = JSON.parse(properties.filters);
+var definitions = JSON.parse(properties.definitions || "{}");
+
+function findFilter(statusCode) {
+  var code = String(statusCode);
+  var range = code.charAt(0) + "XX";
+  if (filters.hasOwnProperty(code)) {
+    return filters[code];
+  } else if (filters.hasOwnProperty(range)) {
+    return filters[range];
+  } else if (filters.hasOwnProperty("default")) {
+    return filters["default"];
+  }
+  return null;
+}
+
+function prune(value, filter) {
+  if (filter && filter.hasOwnProperty("$ref")) {
+    filter = definitions.hasOwnProperty(filter["$ref"]) ? definitions[filter["$ref"]] : null;
+  }
+
+  if (!filter || value === null || typeof value !== "object") {
+    return value;
+  }
+
+  if (filter.type === "array") {
+    if (!Array.isArray(value)) {
+      return value;
+    }
+    return value.map(function (item) {
+      return prune(item, filter.items);
+    });
+  }
+
+  if (filter.type === "object") {
+    if (Array.isArray(value)) {
+      return value;
+    }
+
+    var declared = filter.properties || {};
+    var result = {};
+    for (var key in value) {
+      if (!value.hasOwnProperty(key)) {
+        continue;
+      }
+
+      if (declared.hasOwnProperty(key)) {
+        result[key] = prune(value[key], declared[key]);
+      } else if (filter.additionalProperties === true) {
+        result[key] = value[key];
+      } else if (filter.additionalProperties) {
+        result[key] = prune(value[key], filter.additionalProperties);
+      }
+    }
+    return result;
+  }
+
+  return value;
+}
+
+var filter = findFilter(context.getVariable("response.status.code"));
+var contentType = context.getVariable("response.header.Content-Type") || "";
+var content = context.getVariable("response.content");
+
+if (filter && content && /json/i.test(contentType)) {
+  try {
+    context.setVariable("response.content", JSON.stringify(prune(JSON.parse(content), filter)));
+  } catch (e) {
+    // bodies that are not valid JSON are left unchanged
+  }
+}
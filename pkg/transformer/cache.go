@@ -0,0 +1,188 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"fmt"
+	"github.com/go-errors/errors"
+	v1 "github.com/micovery/spec2proxy/pkg/apigee/v1"
+)
+
+// CacheExtension is the value of the "x-Apigee-Cache" extension, either at the top level of the spec, or within an operation
+type CacheExtension struct {
+	Enabled         *bool    `yaml:"enabled"`
+	TTL             int      `yaml:"ttl"`
+	KeyFragments    []string `yaml:"keyFragments"`
+	SkipCacheLookup bool     `yaml:"skipCacheLookup"`
+}
+
+type KeyFragment struct {
+	KeyFragment struct {
+		Ref   string `yaml:".ref,omitempty"`
+		Value string `yaml:".@,omitempty"`
+	} `yaml:"KeyFragment"`
+}
+
+// KeyFragmentRef returns a cache key fragment that references a flow variable
+func KeyFragmentRef(ref string) *KeyFragment {
+	fragment := &KeyFragment{}
+	fragment.KeyFragment.Ref = ref
+	return fragment
+}
+
+// KeyFragmentValue returns a cache key fragment with a literal value
+func KeyFragmentValue(value string) *KeyFragment {
+	fragment := &KeyFragment{}
+	fragment.KeyFragment.Value = value
+	return fragment
+}
+
+type ResponseCachePolicy struct {
+	ResponseCache struct {
+		Name        string `yaml:".name"`
+		DisplayName string `yaml:"DisplayName"`
+		CacheKey    struct {
+			KeyFragments []*KeyFragment `yaml:".@"`
+		} `yaml:"CacheKey"`
+		Scope          string `yaml:"Scope"`
+		ExpirySettings struct {
+			TimeoutInSec int `yaml:"TimeoutInSec"`
+		} `yaml:"ExpirySettings"`
+		SkipCacheLookup         string `yaml:"SkipCacheLookup,omitempty"`
+		ExcludeErrorResponse    string `yaml:"ExcludeErrorResponse"`
+		UseResponseCacheHeaders string `yaml:"UseResponseCacheHeaders"`
+	} `yaml:"ResponseCache"`
+}
+
+// NoCacheCondition matches requests that ask not to be served from a cache
+const NoCacheCondition = `request.header.Cache-Control JavaRegex "(?i).*\b(no-cache|no-store|max-age=0)\b.*"`
+
+// AddResponseCache adds ResponseCache policies for the GET operations that have the "x-Apigee-Cache" extension
+// (or for all GET operations, when it is at the top level). The operation-level extension takes precedence over the top-level one.
+//
+// The cache key is made of the operation's path, its path parameters, its declared query and header parameters, the credentials
+// of its security schemes, and any extra key fragments from the extension. Undeclared parameters are left out, so that they do not
+// split the cache.
+// The same policy is attached at the end of the request and at the start of the response of the operation's flow,
+// so that the lookup happens after the security steps, and the response is cached before any other response step.
+func AddResponseCache(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, options *Options) error {
+	var err error
+	var globalConfig *CacheExtension
+	if globalConfig, err = cacheConfig(apiProxy.Extensions); err != nil {
+		return err
+	}
+
+	for _, flow := range operationFlows(proxyEndpoint.Flows) {
+		var config *CacheExtension
+		if config, err = cacheConfig(flow.Extensions); err != nil {
+			return err
+		}
+
+		if config == nil {
+			config = globalConfig
+		} else if flow.Verb != "GET" && (config.Enabled == nil || *config.Enabled) {
+			return errors.Errorf("x-Apigee-Cache in operation '%s' is only supported for GET operations", FlowId(flow))
+		}
+
+		if config == nil || flow.Verb != "GET" || (config.Enabled != nil && !*config.Enabled) {
+			continue
+		}
+
+		policy := ResponseCachePolicy{}
+		policy.ResponseCache.Name = fmt.Sprintf("RC-%s", FlowId(flow))
+		policy.ResponseCache.DisplayName = policy.ResponseCache.Name
+		policy.ResponseCache.CacheKey.KeyFragments = cacheKeyFragments(apiProxy, proxyEndpoint, flow, config, options)
+		policy.ResponseCache.Scope = "Exclusive"
+		policy.ResponseCache.ExpirySettings.TimeoutInSec = config.TTL
+		policy.ResponseCache.ExcludeErrorResponse = "true"
+		policy.ResponseCache.UseResponseCacheHeaders = "false"
+		if config.SkipCacheLookup {
+			policy.ResponseCache.SkipCacheLookup = escapeXML(NoCacheCondition)
+		}
+
+		if _, err = AddPolicy(apiProxy, policy); err != nil {
+			return err
+		}
+
+		flow.Request = append(flow.Request, v1.NewStep(policy.ResponseCache.Name, "true"))
+		flow.Response = append([]*v1.Step{v1.NewStep(policy.ResponseCache.Name, "true")}, flow.Response...)
+	}
+
+	return nil
+}
+
+// cacheKeyFragments returns the fragments of the cache key for the operation
+func cacheKeyFragments(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, flow *v1.ConditionalFlow, config *CacheExtension, options *Options) []*KeyFragment {
+	fragments := []*KeyFragment{KeyFragmentValue(PathId(proxyEndpoint, flow.Path))}
+
+	if HasPathParams(flow.Path) {
+		if options.PathVariablesPrefix == "" {
+			fragments = append(fragments, KeyFragmentRef("proxy.pathsuffix"))
+		} else {
+			for _, param := range flow.Parameters {
+				if param.In == "path" {
+					fragments = append(fragments, KeyFragmentRef(fmt.Sprintf("%s.%s", options.PathVariablesPrefix, param.Name)))
+				}
+			}
+		}
+	}
+
+	for _, param := range flow.Parameters {
+		switch param.In {
+		case "query":
+			fragments = append(fragments, KeyFragmentRef(fmt.Sprintf("request.queryparam.%s", param.Name)))
+		case "header":
+			fragments = append(fragments, KeyFragmentRef(fmt.Sprintf("request.header.%s", param.Name)))
+		}
+	}
+
+	//responses of secured operations are cached per caller, so that they are not served to other callers
+	requirements := proxyEndpoint.SecurityRequirement
+	if flow.SecurityRequirement != nil {
+		requirements = flow.SecurityRequirement
+	}
+	for _, ref := range CredentialRefs(apiProxy, requirements) {
+		fragments = append(fragments, KeyFragmentRef(ref))
+	}
+
+	for _, ref := range config.KeyFragments {
+		fragments = append(fragments, KeyFragmentRef(ref))
+	}
+
+	return fragments
+}
+
+// cacheConfig returns the value of the "x-Apigee-Cache" extension, or nil if it is not present
+func cacheConfig(extensions map[string]*v1.Extension) (*CacheExtension, error) {
+	extension, found := extensions["x-Apigee-Cache"]
+	if !found || extension.Value == nil {
+		return nil, nil
+	}
+
+	config := &CacheExtension{}
+	if err := extension.Value.Decode(config); err != nil {
+		return nil, errors.New(err)
+	}
+
+	if config.TTL < 0 {
+		return nil, errors.Errorf("x-Apigee-Cache ttl must not be negative")
+	}
+
+	if config.TTL == 0 {
+		config.TTL = 300
+	}
+
+	return config, nil
+}
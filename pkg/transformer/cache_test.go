@@ -0,0 +1,123 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"github.com/pb33f/libopenapi/datamodel/high/base"
+	"slices"
+	"testing"
+)
+
+func TestCacheKeyFragments(t *testing.T) {
+	query := &v1.Parameter{Name: "limit", In: "query"}
+	header := &v1.Parameter{Name: "Accept-Language", In: "header"}
+	cookie := &v1.Parameter{Name: "session", In: "cookie"}
+	id := &v1.Parameter{Name: "id", In: "path", Required: true}
+
+	tests := []struct {
+		name         string
+		path         string
+		parameters   []*v1.Parameter
+		prefix       string
+		global       []*base.SecurityRequirement
+		requirements []*base.SecurityRequirement
+		keyFragments []string
+		expected     []string
+	}{
+		{
+			name:     "path without parameters",
+			path:     "/pets",
+			prefix:   "oas.path",
+			expected: []string{"v1-pets"},
+		},
+		{
+			name:       "path parameters",
+			path:       "/pets/{id}",
+			parameters: []*v1.Parameter{id},
+			prefix:     "oas.path",
+			expected:   []string{"v1-pets-id", "ref:oas.path.id"},
+		},
+		{
+			name:       "path parameters without path variables",
+			path:       "/pets/{id}",
+			parameters: []*v1.Parameter{id},
+			expected:   []string{"v1-pets-id", "ref:proxy.pathsuffix"},
+		},
+		{
+			name:       "query and header parameters",
+			path:       "/pets",
+			parameters: []*v1.Parameter{query, header, cookie},
+			prefix:     "oas.path",
+			expected:   []string{"v1-pets", "ref:request.queryparam.limit", "ref:request.header.Accept-Language"},
+		},
+		{
+			name:     "global security",
+			path:     "/pets",
+			prefix:   "oas.path",
+			global:   []*base.SecurityRequirement{requirement("apiKey"), requirement("mtls")},
+			expected: []string{"v1-pets", "ref:request.header.x-api-key", "ref:tls.client.s.dn"},
+		},
+		{
+			name:         "operation security",
+			path:         "/pets",
+			prefix:       "oas.path",
+			global:       []*base.SecurityRequirement{requirement("apiKey")},
+			requirements: []*base.SecurityRequirement{requirement("oauth"), requirement("bearer"), requirement("cookieKey")},
+			expected:     []string{"v1-pets", "ref:request.header.Authorization", "ref:spec2proxy.security.cookieKey.apikey"},
+		},
+		{
+			name:         "public operation",
+			path:         "/pets",
+			prefix:       "oas.path",
+			global:       []*base.SecurityRequirement{requirement("apiKey")},
+			requirements: []*base.SecurityRequirement{},
+			expected:     []string{"v1-pets"},
+		},
+		{
+			name:         "extra key fragments",
+			path:         "/pets",
+			prefix:       "oas.path",
+			keyFragments: []string{"request.header.x-tenant"},
+			expected:     []string{"v1-pets", "ref:request.header.x-tenant"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apiProxy := securityTestProxy()
+			apiProxy.SecuritySchemes["cookieKey"] = &v1.SecurityScheme{Name: "cookieKey", Type: "apiKey", In: "cookie", ParamName: "key"}
+
+			flow := &v1.ConditionalFlow{Name: "op", Verb: "GET", Path: test.path, Parameters: test.parameters, SecurityRequirement: test.requirements}
+			proxyEndpoint := &v1.ProxyEndpoint{BasePath: "/v1", Flows: []*v1.ConditionalFlow{flow}, SecurityRequirement: test.global}
+
+			options := NewOptions()
+			options.PathVariablesPrefix = test.prefix
+
+			var actual []string
+			for _, fragment := range cacheKeyFragments(apiProxy, proxyEndpoint, flow, &CacheExtension{KeyFragments: test.keyFragments}, options) {
+				if fragment.KeyFragment.Ref != "" {
+					actual = append(actual, "ref:"+fragment.KeyFragment.Ref)
+				} else {
+					actual = append(actual, fragment.KeyFragment.Value)
+				}
+			}
+
+			if !slices.Equal(actual, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
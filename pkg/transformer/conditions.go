@@ -0,0 +1,215 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"fmt"
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"regexp"
+	"strings"
+)
+
+var pathParamNameRegex = regexp.MustCompile(`{([^}]*)}`)
+
+var formatRegexes = map[string]string{
+	"uuid":      "[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}",
+	"date":      "[0-9]{4}-[0-9]{2}-[0-9]{2}",
+	"date-time": "[0-9]{4}-[0-9]{2}-[0-9]{2}T[^/]+",
+	"int32":     "-?[0-9]+",
+	"int64":     "-?[0-9]+",
+}
+
+var typeRegexes = map[string]string{
+	"integer": "-?[0-9]+",
+	"number":  "-?[0-9]+([.][0-9]+)?",
+	"boolean": "(true|false)",
+}
+
+// BuildFlowCondition returns the condition for a conditional flow.
+// Plain path templates use MatchesPath, while paths that have typed, constrained, suffixed,
+// or multi-segment parameters use JavaRegex so that they can be told apart from literal segments.
+func BuildFlowCondition(flow *v1.ConditionalFlow, options *Options) string {
+	return fmt.Sprintf("(%s) and (request.verb = \"%s\")", BuildPathCondition(flow.Path, flow.Parameters, options), flow.Verb)
+}
+
+// BuildPathCondition returns the proxy.pathsuffix part of a flow condition.
+// In lenient trailing slash mode, the condition also accepts the path with (or without) a trailing slash.
+func BuildPathCondition(oasPath string, parameters []*v1.Parameter, options *Options) string {
+	lenient := options != nil && options.TrailingSlash == TrailingSlashLenient
+
+	if needsRegex(oasPath, parameters) {
+		regex := ToPathRegex(oasPath, parameters)
+		if lenient {
+			regex = strings.TrimSuffix(regex, "/") + "/?"
+		}
+		return fmt.Sprintf("proxy.pathsuffix JavaRegex \"%s\"", regex)
+	}
+
+	condition := matchesPathCondition(ToApigeePath(oasPath))
+	if !lenient {
+		return condition
+	}
+
+	alternatePath := ToApigeePath(oasPath) + "/"
+	if strings.HasSuffix(oasPath, "/") {
+		alternatePath = strings.TrimSuffix(ToApigeePath(oasPath), "/")
+	}
+
+	return fmt.Sprintf("%s or %s", condition, matchesPathCondition(alternatePath))
+}
+
+func matchesPathCondition(apigeePath string) string {
+	//requests to the exact base path have an empty path suffix
+	if apigeePath == "" {
+		return "proxy.pathsuffix = \"\""
+	}
+
+	return fmt.Sprintf("proxy.pathsuffix MatchesPath \"%s\"", apigeePath)
+}
+
+// ToPathRegex converts an OAS path template into a Java regular expression
+func ToPathRegex(oasPath string, parameters []*v1.Parameter) string {
+	var regex strings.Builder
+	last := 0
+	for _, match := range pathParamNameRegex.FindAllStringSubmatchIndex(oasPath, -1) {
+		regex.WriteString(escapeRegexLiteral(oasPath[last:match[0]]))
+		regex.WriteString(paramRegex(findPathParameter(oasPath[match[2]:match[3]], parameters)))
+		last = match[1]
+	}
+	regex.WriteString(escapeRegexLiteral(oasPath[last:]))
+
+	return escapeXML(regex.String())
+}
+
+func needsRegex(oasPath string, parameters []*v1.Parameter) bool {
+	for _, segment := range strings.Split(oasPath, "/") {
+		if !HasPathParams(segment) {
+			continue
+		}
+
+		//suffixed, prefixed or multiple parameters within a single segment
+		if pathParamNameRegex.FindString(segment) != segment {
+			return true
+		}
+
+		param := findPathParameter(strings.Trim(segment, "{}"), parameters)
+		if paramRegex(param) != "[^/]+" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func findPathParameter(name string, parameters []*v1.Parameter) *v1.Parameter {
+	for _, param := range parameters {
+		if param.In == "path" && param.Name == name {
+			return param
+		}
+	}
+	return nil
+}
+
+func paramRegex(param *v1.Parameter) string {
+	if param == nil {
+		return "[^/]+"
+	}
+
+	if param.AllowReserved {
+		return ".+"
+	}
+
+	schema := param.Schema
+	if schema == nil {
+		return "[^/]+"
+	}
+
+	if schema.Pattern != "" {
+		pattern := strings.TrimSuffix(strings.TrimPrefix(schema.Pattern, "^"), "$")
+		return fmt.Sprintf("(%s)", escapeRegexQuotes(pattern))
+	}
+
+	if len(schema.Enum) > 0 {
+		var values []string
+		for _, value := range schema.Enum {
+			values = append(values, escapeRegexLiteral(value.Value))
+		}
+		return fmt.Sprintf("(%s)", strings.Join(values, "|"))
+	}
+
+	if regex, ok := formatRegexes[schema.Format]; ok {
+		return regex
+	}
+
+	for _, schemaType := range schema.Type {
+		if regex, ok := typeRegexes[schemaType]; ok {
+			return regex
+		}
+	}
+
+	return "[^/]+"
+}
+
+// escapeRegexLiteral escapes regex meta-characters using character classes,
+// which avoids having to deal with backslashes within Apigee condition strings
+func escapeRegexLiteral(literal string) string {
+	var escaped strings.Builder
+	for _, char := range literal {
+		if strings.ContainsRune(`.+*?()|{}$`, char) {
+			escaped.WriteString(fmt.Sprintf("[%c]", char))
+		} else if strings.ContainsRune(`[]\^`, char) {
+			escaped.WriteString(fmt.Sprintf("\\%c", char))
+		} else {
+			escaped.WriteRune(char)
+		}
+	}
+	return escaped.String()
+}
+
+// escapeRegexQuotes replaces the double quotes in a regex with the \x22 escape, since a quote
+// would end the string literal of the JavaRegex condition
+func escapeRegexQuotes(regex string) string {
+	var escaped strings.Builder
+	backslash := false
+	for _, char := range regex {
+		if char == '"' {
+			if !backslash {
+				escaped.WriteRune('\\')
+			}
+			//an escaped quote (\") becomes \x22 too
+			escaped.WriteString("x22")
+			backslash = false
+			continue
+		}
+		escaped.WriteRune(char)
+		backslash = char == '\\' && !backslash
+	}
+	return escaped.String()
+}
+
+func escapeXML(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// andConditions joins two flow conditions, ignoring the ones that are always true
+func andConditions(condition1 string, condition2 string) string {
+	if condition2 == "" || condition2 == "true" {
+		return condition1
+	}
+	if condition1 == "" || condition1 == "true" {
+		return condition2
+	}
+	return fmt.Sprintf("(%s) and (%s)", condition1, condition2)
+}
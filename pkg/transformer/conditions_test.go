@@ -0,0 +1,152 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"github.com/pb33f/libopenapi/datamodel/high/base"
+	"gopkg.in/yaml.v3"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func pathParameter(name string, schema *base.Schema) *v1.Parameter {
+	return &v1.Parameter{Name: name, In: "path", Required: true, Schema: schema}
+}
+
+// conditionRegex returns the regex of a JavaRegex condition, as it is seen by the regex engine.
+// Java regexes are compatible with Go regexes for the patterns used in these tests.
+func conditionRegex(t *testing.T, condition string) *regexp.Regexp {
+	match := regexp.MustCompile(`^proxy\.pathsuffix JavaRegex "([^"]*)"$`).FindStringSubmatch(condition)
+	if match == nil {
+		t.Fatalf("expected a single JavaRegex string literal, got '%s'", condition)
+	}
+	regex := strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&").Replace(match[1])
+	return regexp.MustCompile("^(?:" + regex + ")$")
+}
+
+func TestBuildPathCondition(t *testing.T) {
+	enum := &base.Schema{Type: []string{"string"}, Enum: []*yaml.Node{{Kind: yaml.ScalarNode, Value: "a.b"}, {Kind: yaml.ScalarNode, Value: "c"}}}
+
+	tests := []struct {
+		name       string
+		path       string
+		parameters []*v1.Parameter
+		lenient    bool
+		matches    []string
+		rejects    []string
+	}{
+		{
+			name:       "integer",
+			path:       "/pets/{id}",
+			parameters: []*v1.Parameter{pathParameter("id", &base.Schema{Type: []string{"integer"}})},
+			matches:    []string{"/pets/1", "/pets/-12"},
+			rejects:    []string{"/pets/abc", "/pets/1/", "/pets/1/toys"},
+		},
+		{
+			name:       "uuid",
+			path:       "/pets/{id}",
+			parameters: []*v1.Parameter{pathParameter("id", &base.Schema{Type: []string{"string"}, Format: "uuid"})},
+			matches:    []string{"/pets/123e4567-e89b-12d3-a456-426614174000"},
+			rejects:    []string{"/pets/123"},
+		},
+		{
+			name:       "enum",
+			path:       "/pets/{kind}",
+			parameters: []*v1.Parameter{pathParameter("kind", enum)},
+			matches:    []string{"/pets/a.b", "/pets/c"},
+			rejects:    []string{"/pets/axb", "/pets/d"},
+		},
+		{
+			name:       "suffixed parameter",
+			path:       "/files/{name}.json",
+			parameters: []*v1.Parameter{pathParameter("name", &base.Schema{Type: []string{"string"}})},
+			matches:    []string{"/files/a.json"},
+			rejects:    []string{"/files/a.xml", "/files/a/b.json"},
+		},
+		{
+			name:       "pattern with anchors",
+			path:       "/pets/{name}",
+			parameters: []*v1.Parameter{pathParameter("name", &base.Schema{Type: []string{"string"}, Pattern: "^[a-z]+$"})},
+			matches:    []string{"/pets/rex"},
+			rejects:    []string{"/pets/Rex", "/pets/"},
+		},
+		{
+			name:       "pattern with quotes",
+			path:       "/tags/{tag}",
+			parameters: []*v1.Parameter{pathParameter("tag", &base.Schema{Type: []string{"string"}, Pattern: `^"[a-z]+"|[^"/]+\"$`})},
+			matches:    []string{`/tags/"a"`, `/tags/b"`},
+			rejects:    []string{`/tags/"A"`, `/tags/b`},
+		},
+		{
+			name:       "pattern with escaped backslash before a quote",
+			path:       "/tags/{tag}",
+			parameters: []*v1.Parameter{pathParameter("tag", &base.Schema{Type: []string{"string"}, Pattern: `^a\\"$`})},
+			matches:    []string{`/tags/a\"`},
+			rejects:    []string{`/tags/a"`},
+		},
+		{
+			name:       "reserved characters",
+			path:       "/files/{path}",
+			parameters: []*v1.Parameter{{Name: "path", In: "path", AllowReserved: true}},
+			matches:    []string{"/files/a/b/c"},
+			rejects:    []string{"/files/"},
+		},
+		{
+			name:       "lenient trailing slash",
+			path:       "/pets/{id}",
+			parameters: []*v1.Parameter{pathParameter("id", &base.Schema{Type: []string{"integer"}})},
+			lenient:    true,
+			matches:    []string{"/pets/1", "/pets/1/"},
+			rejects:    []string{"/pets/1//"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := NewOptions()
+			if test.lenient {
+				options.TrailingSlash = TrailingSlashLenient
+			}
+
+			regex := conditionRegex(t, BuildPathCondition(test.path, test.parameters, options))
+			for _, path := range test.matches {
+				if !regex.MatchString(path) {
+					t.Errorf("expected '%s' to match %s", path, regex)
+				}
+			}
+			for _, path := range test.rejects {
+				if regex.MatchString(path) {
+					t.Errorf("expected '%s' not to match %s", path, regex)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildPathConditionMatchesPath(t *testing.T) {
+	tests := map[string]string{
+		"/pets/{id}": `proxy.pathsuffix MatchesPath "/pets/*"`,
+		"":           `proxy.pathsuffix = ""`,
+	}
+
+	for path, expected := range tests {
+		parameters := []*v1.Parameter{pathParameter("id", &base.Schema{Type: []string{"string"}})}
+		if actual := BuildPathCondition(path, parameters, NewOptions()); actual != expected {
+			t.Errorf("expected the condition of '%s' to be '%s', got '%s'", path, expected, actual)
+		}
+	}
+}
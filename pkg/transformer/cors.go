@@ -0,0 +1,261 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"fmt"
+	"github.com/go-errors/errors"
+	v1 "github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"slices"
+	"strings"
+)
+
+const (
+	// CORSPolicyTypeCORS generates the Apigee CORS policy
+	CORSPolicyTypeCORS = "cors"
+
+	// CORSPolicyTypeAssignMessage generates AssignMessage policies that set the CORS headers (for runtimes without the CORS policy)
+	CORSPolicyTypeAssignMessage = "assign-message"
+)
+
+// PreflightCondition matches CORS preflight requests
+const PreflightCondition = `(request.verb = "OPTIONS") and (request.header.origin != null) and (request.header.Access-Control-Request-Method != null)`
+
+// CORSExtension is the value of the "x-Apigee-CORS" extension, either at the top level of the spec, or within a path
+type CORSExtension struct {
+	Enabled          *bool    `yaml:"enabled"`
+	Policy           string   `yaml:"policy"`
+	AllowOrigins     []string `yaml:"allowOrigins"`
+	AllowHeaders     []string `yaml:"allowHeaders"`
+	ExposeHeaders    []string `yaml:"exposeHeaders"`
+	MaxAge           int      `yaml:"maxAge"`
+	AllowCredentials bool     `yaml:"allowCredentials"`
+}
+
+type CORSPolicy struct {
+	CORS struct {
+		Name                      string `yaml:".name"`
+		DisplayName               string `yaml:"DisplayName"`
+		AllowOrigins              string `yaml:"AllowOrigins"`
+		AllowMethods              string `yaml:"AllowMethods"`
+		AllowHeaders              string `yaml:"AllowHeaders"`
+		ExposeHeaders             string `yaml:"ExposeHeaders,omitempty"`
+		MaxAge                    int    `yaml:"MaxAge"`
+		AllowCredentials          bool   `yaml:"AllowCredentials"`
+		GeneratePreflightResponse bool   `yaml:"GeneratePreflightResponse"`
+		IgnoreUnresolvedVariables bool   `yaml:"IgnoreUnresolvedVariables"`
+	} `yaml:"CORS"`
+}
+
+// AddCORS adds the CORS policies for the paths that have the "x-Apigee-CORS" extension (or for all paths, when it is at the top level).
+// The policies go in the PreFlow, and each path gets a preflight flow that comes before any other flow.
+// Preflight requests skip the rest of the PreFlow request steps, since browsers do not send credentials with them.
+// This must be the last step when generating policies, so that no other PreFlow step is added afterward.
+func AddCORS(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, options *Options) error {
+	var err error
+	var requestSteps []*v1.Step
+	var responseSteps []*v1.Step
+	var preflightFlows []*v1.ConditionalFlow
+
+	paths, flowsByPath := GroupFlowsByPath(operationFlows(proxyEndpoint.Flows))
+	for _, path := range paths {
+		pathFlows := flowsByPath[path]
+
+		var config *CORSExtension
+		if config, err = corsConfig(apiProxy, pathFlows[0]); err != nil {
+			return err
+		}
+		if config == nil {
+			continue
+		}
+
+		pathId := PathId(proxyEndpoint, path)
+		pathCondition := BuildPathCondition(path, pathFlows[0].Parameters, options)
+		corsCondition := fmt.Sprintf("(%s) and (request.header.origin != null)", pathCondition)
+		allowMethods := strings.Join(AllowedVerbs(pathFlows, options), ", ")
+
+		var noRoutePolicyName string
+		if noRoutePolicyName, err = AddNoRoute(apiProxy, proxyEndpoint); err != nil {
+			return err
+		}
+
+		//the flow has no verb, even though it only handles OPTIONS requests, since it is not an operation (see operationFlows)
+		preflightFlow := &v1.ConditionalFlow{
+			Name:        fmt.Sprintf("cors-preflight-%s", pathId),
+			Description: fmt.Sprintf("Responds to CORS preflight requests for %s", DisplayPath(proxyEndpoint, path)),
+			Condition:   fmt.Sprintf("(%s) and (%s)", pathCondition, PreflightCondition),
+			Request:     []*v1.Step{v1.NewStep(noRoutePolicyName, "true")},
+			Response:    []*v1.Step{},
+			Extensions:  map[string]*v1.Extension{},
+			Path:        path,
+		}
+
+		switch config.Policy {
+		case CORSPolicyTypeCORS:
+			policy := CORSPolicy{}
+			policy.CORS.Name = fmt.Sprintf("CORS-%s", pathId)
+			policy.CORS.DisplayName = policy.CORS.Name
+			policy.CORS.AllowOrigins = strings.Join(config.AllowOrigins, ", ")
+			policy.CORS.AllowMethods = allowMethods
+			policy.CORS.AllowHeaders = strings.Join(config.AllowHeaders, ", ")
+			policy.CORS.ExposeHeaders = strings.Join(config.ExposeHeaders, ", ")
+			policy.CORS.MaxAge = config.MaxAge
+			policy.CORS.AllowCredentials = config.AllowCredentials
+			policy.CORS.GeneratePreflightResponse = true
+			policy.CORS.IgnoreUnresolvedVariables = true
+
+			if _, err = AddPolicy(apiProxy, policy); err != nil {
+				return err
+			}
+
+			//the CORS policy takes care of both the preflight response, and the headers of the actual response
+			requestSteps = append(requestSteps, v1.NewStep(policy.CORS.Name, corsCondition))
+		case CORSPolicyTypeAssignMessage:
+			originCondition, originHeaders := corsOriginHeaders(config)
+			corsCondition = andConditions(corsCondition, originCondition)
+
+			responsePolicy := NewAssignMessagePolicy(fmt.Sprintf("AM-CORS-%s", pathId))
+			responsePolicy.AssignMessage.Set = &MessageSet{Headers: slices.Clone(originHeaders)}
+			if len(config.ExposeHeaders) > 0 {
+				responsePolicy.AssignMessage.Set.Headers = append(responsePolicy.AssignMessage.Set.Headers,
+					NewHeader("Access-Control-Expose-Headers", strings.Join(config.ExposeHeaders, ", ")))
+			}
+
+			preflightPolicy := NewAssignMessagePolicy(fmt.Sprintf("AM-CORSPreflight-%s", pathId))
+			preflightPolicy.AssignMessage.AssignTo = &AssignTo{CreateNew: "false", Transport: "http", Type: "response"}
+			preflightPolicy.AssignMessage.Set = &MessageSet{
+				Headers: append(slices.Clone(originHeaders),
+					NewHeader("Access-Control-Allow-Methods", allowMethods),
+					NewHeader("Access-Control-Allow-Headers", strings.Join(config.AllowHeaders, ", ")),
+					NewHeader("Access-Control-Max-Age", fmt.Sprintf("%d", config.MaxAge))),
+				StatusCode:   "204",
+				ReasonPhrase: "No Content",
+			}
+
+			if _, err = AddPolicy(apiProxy, responsePolicy); err != nil {
+				return err
+			}
+
+			if _, err = AddPolicy(apiProxy, preflightPolicy); err != nil {
+				return err
+			}
+
+			responseSteps = append(responseSteps, v1.NewStep(responsePolicy.AssignMessage.Name,
+				fmt.Sprintf("(%s) and not (%s)", corsCondition, PreflightCondition)))
+			preflightFlow.Response = append(preflightFlow.Response, v1.NewStep(preflightPolicy.AssignMessage.Name, originCondition))
+		}
+
+		preflightFlows = append(preflightFlows, preflightFlow)
+	}
+
+	if len(preflightFlows) == 0 {
+		return nil
+	}
+
+	for _, step := range proxyEndpoint.PreFlow.Request {
+		step.Step.Condition = andConditions(fmt.Sprintf("not (%s)", PreflightCondition), step.Step.Condition)
+	}
+
+	proxyEndpoint.PreFlow.Request = append(requestSteps, proxyEndpoint.PreFlow.Request...)
+	proxyEndpoint.PreFlow.Response = append(proxyEndpoint.PreFlow.Response, responseSteps...)
+	proxyEndpoint.Flows = append(preflightFlows, proxyEndpoint.Flows...)
+	return nil
+}
+
+// corsConfig returns the CORS configuration for the path of the given flow, or nil if CORS is not enabled for it.
+// The path-level extension takes precedence over the top-level one.
+func corsConfig(apiProxy *v1.APIProxy, flow *v1.ConditionalFlow) (*CORSExtension, error) {
+	extension, found := flow.Extensions["x-Apigee-CORS"]
+	if !found {
+		extension, found = apiProxy.Extensions["x-Apigee-CORS"]
+	}
+	if !found || extension.Value == nil {
+		return nil, nil
+	}
+
+	config := &CORSExtension{}
+	if err := extension.Value.Decode(config); err != nil {
+		return nil, errors.New(err)
+	}
+
+	if config.Enabled != nil && !*config.Enabled {
+		return nil, nil
+	}
+
+	if config.Policy == "" {
+		config.Policy = CORSPolicyTypeCORS
+	}
+
+	switch config.Policy {
+	case CORSPolicyTypeCORS, CORSPolicyTypeAssignMessage:
+	default:
+		return nil, errors.Errorf("x-Apigee-CORS policy '%s' is not supported", config.Policy)
+	}
+
+	if len(config.AllowOrigins) == 0 {
+		config.AllowOrigins = []string{"*"}
+	}
+
+	if len(config.AllowHeaders) == 0 {
+		//allow whatever headers the browser asks for
+		config.AllowHeaders = []string{"{request.header.Access-Control-Request-Headers}"}
+	}
+
+	if config.MaxAge == 0 {
+		config.MaxAge = 3600
+	}
+
+	return config, nil
+}
+
+// corsOriginHeaders returns the condition that matches the allowed origins, and the headers that allow the origin
+func corsOriginHeaders(config *CORSExtension) (string, []*Header) {
+	var headers []*Header
+	var condition string
+
+	allowAll := slices.Contains(config.AllowOrigins, "*")
+	if allowAll && !config.AllowCredentials {
+		headers = append(headers, NewHeader("Access-Control-Allow-Origin", "*"))
+	} else {
+		//the allowed origin must be echoed back, since the header does not accept a list
+		headers = append(headers, NewHeader("Access-Control-Allow-Origin", "{request.header.origin}"), NewHeader("Vary", "Origin"))
+		if !allowAll {
+			var origins []string
+			for _, origin := range config.AllowOrigins {
+				origins = append(origins, escapeRegexLiteral(origin))
+			}
+			condition = fmt.Sprintf("request.header.origin JavaRegex \"(%s)\"", strings.Join(origins, "|"))
+		}
+	}
+
+	if config.AllowCredentials {
+		headers = append(headers, NewHeader("Access-Control-Allow-Credentials", "true"))
+	}
+
+	return condition, headers
+}
+
+// operationFlows returns the flows that were generated from spec operations, which are the only ones with a verb.
+// The path is not checked, since the root operation of a split proxy endpoint has an empty path.
+func operationFlows(flows []*v1.ConditionalFlow) []*v1.ConditionalFlow {
+	var result []*v1.ConditionalFlow
+	for _, flow := range flows {
+		if flow.Verb == "" {
+			continue
+		}
+		result = append(result, flow)
+	}
+	return result
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"fmt"
+	"github.com/go-errors/errors"
+	v1 "github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// SunsetExtension is the value of the "x-sunset" extension of a deprecated operation.
+// It can also be written as just the sunset date (e.g. "x-sunset: 2025-06-30").
+type SunsetExtension struct {
+	Date string `yaml:"date"`
+	Link string `yaml:"link"`
+}
+
+// AddDeprecation adds the "Deprecation" header (and the RFC 8594 "Sunset" header, with a "Link" to the migration docs,
+// when the operation has the "x-sunset" extension) to the responses of the operations marked as deprecated in the spec.
+// With the EnforceSunset option, the operations respond 410 once the sunset date has passed. The date is checked at runtime,
+// so the proxy does not need to be redeployed when the sunset date comes.
+func AddDeprecation(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, options *Options) error {
+	var err error
+	for _, flow := range operationFlows(proxyEndpoint.Flows) {
+		var sunset *SunsetExtension
+		if sunset, err = sunsetConfig(flow); err != nil {
+			return err
+		}
+
+		if !flow.Deprecated {
+			if sunset != nil {
+				return errors.Errorf("x-sunset in operation '%s' requires the operation to be deprecated", FlowId(flow))
+			}
+			continue
+		}
+
+		headers := []*Header{NewHeader("Deprecation", "true")}
+		var sunsetTime time.Time
+		if sunset != nil {
+			if sunsetTime, err = parseSunsetDate(sunset.Date); err != nil {
+				return errors.Errorf("x-sunset in operation '%s' has an invalid date '%s'. e.g. 2025-06-30 or 2025-06-30T00:00:00Z", FlowId(flow), sunset.Date)
+			}
+
+			headers = append(headers, NewHeader("Sunset", sunsetTime.UTC().Format(http.TimeFormat)))
+			if sunset.Link != "" {
+				headers = append(headers, NewHeader("Link", escapeXML(fmt.Sprintf(`<%s>; rel="deprecation"; type="text/html"`, sunset.Link))))
+			}
+		}
+
+		policy := NewAssignMessagePolicy(fmt.Sprintf("AM-Deprecation-%s", FlowId(flow)))
+		policy.AssignMessage.Set = &MessageSet{Headers: headers}
+		if _, err = AddPolicy(apiProxy, policy); err != nil {
+			return err
+		}
+
+		flow.Response = append(flow.Response, v1.NewStep(policy.AssignMessage.Name, "true"))
+
+		if !options.EnforceSunset || sunset == nil {
+			continue
+		}
+
+		gonePolicy := NewRaiseFaultPolicy(fmt.Sprintf("RF-Sunset-%s", FlowId(flow)), "410", "Gone")
+		gonePolicy.RaiseFault.FaultResponse.Set.Headers = slices.Clone(headers)
+		if _, err = AddPolicy(apiProxy, gonePolicy); err != nil {
+			return err
+		}
+
+		//system.timestamp is the current time, in milliseconds since the epoch
+		flow.Request = append([]*v1.Step{v1.NewStep(gonePolicy.RaiseFault.Name, escapeXML(fmt.Sprintf("system.timestamp >= %d", sunsetTime.UnixMilli())))}, flow.Request...)
+	}
+
+	return nil
+}
+
+// sunsetConfig returns the value of the "x-sunset" extension of the operation, or nil if it is not present
+func sunsetConfig(flow *v1.ConditionalFlow) (*SunsetExtension, error) {
+	extension, found := flow.Extensions["x-sunset"]
+	if !found || extension.Value == nil {
+		return nil, nil
+	}
+
+	config := &SunsetExtension{}
+	if extension.Value.Kind == yaml.ScalarNode {
+		config.Date = extension.Value.Value
+	} else if err := extension.Value.Decode(config); err != nil {
+		return nil, errors.New(err)
+	}
+
+	return config, nil
+}
+
+// parseSunsetDate parses a date (midnight UTC) or a date-time, as written in the spec
+func parseSunsetDate(date string) (time.Time, error) {
+	if sunsetTime, err := time.Parse(time.DateOnly, date); err == nil {
+		return sunsetTime, nil
+	}
+	return time.Parse(time.RFC3339, date)
+}
@@ -0,0 +1,199 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/gosimple/slug"
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"gopkg.in/yaml.v3"
+	"strings"
+)
+
+// ProxyEndpointExtension is the value of the "x-Apigee-ProxyEndpoint" extension.
+// It can be written either as a plain endpoint name, or as an object with name and basePath.
+type ProxyEndpointExtension struct {
+	Name     string `yaml:"name"`
+	BasePath string `yaml:"basePath"`
+}
+
+func (e *ProxyEndpointExtension) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		e.Name = node.Value
+		return nil
+	}
+
+	type plain ProxyEndpointExtension
+	return node.Decode((*plain)(e))
+}
+
+// SplitProxyEndpoints moves operations into their own ProxyEndpoint, based on the "x-Apigee-ProxyEndpoint"
+// extension, or the first OAS tag of the operation (when splitting by tag is enabled).
+// Each new endpoint gets a base path made of the original base path, and the common prefix of its operations' paths.
+func SplitProxyEndpoints(apiProxy *v1.APIProxy, options *Options) error {
+	if len(apiProxy.ProxyEndpoints) != 1 {
+		return nil
+	}
+
+	defaultEndpoint := apiProxy.ProxyEndpoints[0]
+
+	var err error
+	var names []string
+	configs := make(map[string]*ProxyEndpointExtension)
+	flowsByEndpoint := make(map[string][]*v1.ConditionalFlow)
+	for _, flow := range defaultEndpoint.Flows {
+		var config *ProxyEndpointExtension
+		if config, err = getProxyEndpointConfig(flow, options); err != nil {
+			return err
+		}
+
+		name := defaultEndpoint.Name
+		if config != nil {
+			name = config.Name
+			if existing, found := configs[name]; !found || existing.BasePath == "" {
+				configs[name] = config
+			}
+		}
+
+		if _, found := flowsByEndpoint[name]; !found {
+			names = append(names, name)
+		}
+		flowsByEndpoint[name] = append(flowsByEndpoint[name], flow)
+	}
+
+	if len(configs) == 0 {
+		return nil
+	}
+
+	var endpoints []*v1.ProxyEndpoint
+	basePaths := make(map[string]string)
+	for _, name := range names {
+		flows := flowsByEndpoint[name]
+		if name == defaultEndpoint.Name {
+			defaultEndpoint.Flows = flows
+			endpoints = append(endpoints, defaultEndpoint)
+			basePaths[defaultEndpoint.BasePath] = name
+			continue
+		}
+
+		var endpoint *v1.ProxyEndpoint
+		if endpoint, err = newSplitProxyEndpoint(defaultEndpoint, configs[name], flows, options); err != nil {
+			return err
+		}
+
+		if other, found := basePaths[endpoint.BasePath]; found {
+			return errors.Errorf("proxy endpoints '%s' and '%s' have the same base path '%s', use the x-Apigee-ProxyEndpoint extension to set a different basePath", other, name, endpoint.BasePath)
+		}
+		basePaths[endpoint.BasePath] = name
+		endpoints = append(endpoints, endpoint)
+	}
+
+	apiProxy.ProxyEndpoints = endpoints
+	return nil
+}
+
+func getProxyEndpointConfig(flow *v1.ConditionalFlow, options *Options) (*ProxyEndpointExtension, error) {
+	if extension, found := flow.Extensions["x-Apigee-ProxyEndpoint"]; found && extension.Value != nil {
+		config := &ProxyEndpointExtension{}
+		if err := extension.Value.Decode(config); err != nil {
+			return nil, errors.New(err)
+		}
+		if config.Name == "" {
+			return nil, errors.Errorf("x-Apigee-ProxyEndpoint extension in operation '%s' is missing the name", FlowId(flow))
+		}
+		config.Name = slug.Make(config.Name)
+		return config, nil
+	}
+
+	if options.SplitByTag && len(flow.Tags) > 0 {
+		return &ProxyEndpointExtension{Name: slug.Make(flow.Tags[0])}, nil
+	}
+
+	return nil, nil
+}
+
+func newSplitProxyEndpoint(source *v1.ProxyEndpoint, config *ProxyEndpointExtension, flows []*v1.ConditionalFlow, options *Options) (*v1.ProxyEndpoint, error) {
+	var prefix string
+	if config.BasePath != "" {
+		prefix = strings.TrimPrefix(NormalizeBasePath(config.BasePath), strings.TrimSuffix(source.BasePath, "/"))
+		if !strings.HasPrefix(NormalizeBasePath(config.BasePath), strings.TrimSuffix(source.BasePath, "/")+"/") {
+			return nil, errors.Errorf("base path '%s' of proxy endpoint '%s' must be within '%s'", config.BasePath, config.Name, source.BasePath)
+		}
+	} else {
+		prefix = commonPathPrefix(flows)
+	}
+
+	for _, flow := range flows {
+		if flow.Path != prefix && !strings.HasPrefix(flow.Path, prefix+"/") {
+			return nil, errors.Errorf("path '%s' is not within the base path of proxy endpoint '%s'", flow.Path, config.Name)
+		}
+		flow.Path = strings.TrimPrefix(flow.Path, prefix)
+		flow.Condition = BuildFlowCondition(flow, options)
+	}
+
+	endpoint := &v1.ProxyEndpoint{
+		Name:     config.Name,
+		BasePath: NormalizeBasePath(strings.TrimSuffix(source.BasePath, "/") + prefix),
+		PreFlow: &v1.UnconditionalFlow{
+			Request:  []*v1.Step{},
+			Response: []*v1.Step{},
+		},
+		Flows: flows,
+		PostFlow: &v1.UnconditionalFlow{
+			Request:  []*v1.Step{},
+			Response: []*v1.Step{},
+		},
+		RouteRules:          []*v1.RouteRule{},
+		SecurityRequirement: source.SecurityRequirement,
+		Extensions:          source.Extensions,
+	}
+
+	for _, routeRule := range source.RouteRules {
+		newRouteRule := *routeRule
+		endpoint.RouteRules = append(endpoint.RouteRules, &newRouteRule)
+	}
+
+	return endpoint, nil
+}
+
+// commonPathPrefix returns the literal path segments shared by all the flows, up to the first templated segment
+func commonPathPrefix(flows []*v1.ConditionalFlow) string {
+	var common []string
+	for index, flow := range flows {
+		var literals []string
+		for _, segment := range strings.Split(strings.Trim(flow.Path, "/"), "/") {
+			if segment == "" || HasPathParams(segment) {
+				break
+			}
+			literals = append(literals, segment)
+		}
+
+		if index == 0 {
+			common = literals
+			continue
+		}
+
+		length := 0
+		for length < len(common) && length < len(literals) && common[length] == literals[length] {
+			length++
+		}
+		common = common[:length]
+	}
+
+	if len(common) == 0 {
+		return ""
+	}
+	return "/" + strings.Join(common, "/")
+}
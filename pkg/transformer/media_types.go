@@ -0,0 +1,127 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"fmt"
+	v1 "github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"strings"
+)
+
+// AddMediaTypeValidation adds RaiseFault policies that reject requests with a "Content-Type" header that is not one
+// of the media types of the operation's request body (with HTTP 415), and requests with an "Accept" header that
+// cannot be satisfied by any of the media types of the operation's responses (with HTTP 406).
+// Media ranges are handled on both sides (e.g. "application/*" in the spec, or "*/*" in the "Accept" header), and
+// "Accept" entries with "q=0" are ignored. Requests without the headers are not checked.
+func AddMediaTypeValidation(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, options *Options) error {
+	if !options.MediaTypeValidation {
+		return nil
+	}
+
+	var err error
+	for _, flow := range operationFlows(proxyEndpoint.Flows) {
+		var steps []*v1.Step
+
+		if flow.RequestBody != nil {
+			if regex := contentTypeRegex(mediaTypeRanges(flow.RequestBody.Content)); regex != "" {
+				policy := NewRaiseFaultPolicy(fmt.Sprintf("RF-HTTP415-%s", FlowId(flow)), "415", "Unsupported Media Type")
+				if _, err = AddPolicy(apiProxy, policy); err != nil {
+					return err
+				}
+
+				condition := fmt.Sprintf(`(request.header.Content-Type != null) and not (request.header.Content-Type JavaRegex "%s")`, regex)
+				steps = append(steps, v1.NewStep(policy.RaiseFault.Name, condition))
+			}
+		}
+
+		var produces []*v1.MediaType
+		for _, response := range flow.Responses {
+			produces = append(produces, response.Content...)
+		}
+
+		if regex := acceptRegex(mediaTypeRanges(produces)); regex != "" {
+			policy := NewRaiseFaultPolicy(fmt.Sprintf("RF-HTTP406-%s", FlowId(flow)), "406", "Not Acceptable")
+			if _, err = AddPolicy(apiProxy, policy); err != nil {
+				return err
+			}
+
+			//the Accept header may have multiple comma separated values, so all of them are checked at once
+			condition := fmt.Sprintf(`(request.header.Accept != null) and not (request.header.Accept.values.string JavaRegex "%s")`, regex)
+			steps = append(steps, v1.NewStep(policy.RaiseFault.Name, condition))
+		}
+
+		flow.Request = append(steps, flow.Request...)
+	}
+
+	return nil
+}
+
+// mediaTypeRanges returns the distinct media types (without parameters, in lower case) of the given content.
+// It returns nil if any of them is "*/*", since any media type is then allowed.
+func mediaTypeRanges(content []*v1.MediaType) []string {
+	var ranges []string
+	for _, mediaType := range content {
+		mediaRange := strings.ToLower(strings.TrimSpace(strings.Split(mediaType.ContentType, ";")[0]))
+		if mediaRange == "*/*" || mediaRange == "*" {
+			return nil
+		}
+
+		if mediaRange != "" {
+			ranges = AppendUnique(ranges, mediaRange)
+		}
+	}
+	return ranges
+}
+
+// mediaTypeRegex returns the regex for the media types within the range (e.g. "application/*" matches "application/json")
+func mediaTypeRegex(mediaRange string) string {
+	if mainType, found := strings.CutSuffix(mediaRange, "/*"); found {
+		return fmt.Sprintf(`%s/[^\s;,]+`, escapeRegexLiteral(mainType))
+	}
+	return escapeRegexLiteral(mediaRange)
+}
+
+// contentTypeRegex returns the regex for the "Content-Type" header values that match any of the media ranges
+func contentTypeRegex(ranges []string) string {
+	if len(ranges) == 0 {
+		return ""
+	}
+
+	var regexes []string
+	for _, mediaRange := range ranges {
+		regexes = append(regexes, mediaTypeRegex(mediaRange))
+	}
+	return fmt.Sprintf(`(?i)\s*(%s)\s*(;.*)?`, strings.Join(regexes, "|"))
+}
+
+// acceptRegex returns the regex for the "Accept" header values that have at least one media range (without "q=0")
+// that matches any of the given media ranges
+func acceptRegex(ranges []string) string {
+	if len(ranges) == 0 {
+		return ""
+	}
+
+	regexes := []string{`\*/\*`}
+	for _, mediaRange := range ranges {
+		mainType, _, _ := strings.Cut(mediaRange, "/")
+		regexes = AppendUnique(regexes, fmt.Sprintf(`%s/\*`, escapeRegexLiteral(mainType)))
+		regexes = AppendUnique(regexes, mediaTypeRegex(mediaRange))
+	}
+
+	return fmt.Sprintf(`(?i)(.*,)?\s*(%s)\s*(;%s[^;,]*)*(,.*)?`, strings.Join(regexes, "|"), acceptNotRejected)
+}
+
+// acceptNotRejected is the lookahead that skips the "Accept" header parameters with "q=0"
+const acceptNotRejected = `(?!\s*q\s*=\s*0(\.0*)?\s*(;|,|$))`
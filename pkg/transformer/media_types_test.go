@@ -0,0 +1,168 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// acceptParameter matches the "Accept" header parameters that are not "q=0". Go regexes do not support lookaheads,
+// so it replaces the acceptNotRejected lookahead (and the parameter that follows) in the tests.
+const acceptParameter = `\s*(|[^;,\sq][^;,]*|q\s*(|[^;,\s=][^;,]*|=\s*(|[^;,\s0][^;,]*|0([^;,\s.][^;,]*|\.0*[^;,\s0][^;,]*|(\.0*)?\s+[^;,\s][^;,]*))))`
+
+func matcher(regex string) *regexp.Regexp {
+	regex = strings.Replace(regex, acceptNotRejected+`[^;,]*`, acceptParameter, 1)
+	return regexp.MustCompile("^(?:" + regex + ")$")
+}
+
+func TestContentTypeRegex(t *testing.T) {
+	tests := []struct {
+		name    string
+		ranges  []string
+		matches []string
+		rejects []string
+	}{
+		{
+			name:    "media types",
+			ranges:  []string{"application/json", "application/problem+json"},
+			matches: []string{"application/json", "Application/JSON", " application/json ; charset=utf-8", "application/problem+json"},
+			rejects: []string{"application/jsonp", "application/xjson", "text/plain", "application/xml; x=application/json"},
+		},
+		{
+			name:    "media range",
+			ranges:  []string{"text/*"},
+			matches: []string{"text/plain", "text/csv; header=present"},
+			rejects: []string{"text/", "application/text"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			regex := matcher(contentTypeRegex(test.ranges))
+			for _, value := range test.matches {
+				if !regex.MatchString(value) {
+					t.Errorf("expected %q to match %s", value, regex)
+				}
+			}
+			for _, value := range test.rejects {
+				if regex.MatchString(value) {
+					t.Errorf("expected %q not to match %s", value, regex)
+				}
+			}
+		})
+	}
+
+	if regex := contentTypeRegex(nil); regex != "" {
+		t.Errorf("expected no regex without media types, got '%s'", regex)
+	}
+}
+
+func TestAcceptRegex(t *testing.T) {
+	tests := []struct {
+		name    string
+		ranges  []string
+		matches []string
+		rejects []string
+	}{
+		{
+			name:   "media type",
+			ranges: []string{"application/json"},
+			matches: []string{
+				"application/json",
+				"APPLICATION/JSON",
+				"*/*",
+				"application/*",
+				"text/html, application/json;q=0.5",
+				"application/json; q=0.01",
+				"application/json; level=1; q=1, text/html",
+				"text/html;q=0, */*;q=0.1",
+			},
+			rejects: []string{
+				"text/html",
+				"text/*",
+				"application/jsonp",
+				"application/json;q=0",
+				"application/json;q=0.",
+				"application/json; q = 0.000",
+				"application/json;level=1;q=0, text/html",
+				"text/html, */*;q=0",
+			},
+		},
+		{
+			name:    "media range",
+			ranges:  []string{"text/*"},
+			matches: []string{"text/csv", "text/*", "*/*"},
+			rejects: []string{"application/json", "text/csv;q=0"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			regex := matcher(acceptRegex(test.ranges))
+			for _, value := range test.matches {
+				if !regex.MatchString(value) {
+					t.Errorf("expected %q to match %s", value, regex)
+				}
+			}
+			for _, value := range test.rejects {
+				if regex.MatchString(value) {
+					t.Errorf("expected %q not to match %s", value, regex)
+				}
+			}
+		})
+	}
+
+	if regex := acceptRegex(nil); regex != "" {
+		t.Errorf("expected no regex without media types, got '%s'", regex)
+	}
+}
+
+func TestMediaTypeRanges(t *testing.T) {
+	content := func(contentTypes ...string) []*v1.MediaType {
+		var result []*v1.MediaType
+		for _, contentType := range contentTypes {
+			result = append(result, &v1.MediaType{ContentType: contentType})
+		}
+		return result
+	}
+
+	tests := []struct {
+		name     string
+		content  []*v1.MediaType
+		expected []string
+	}{
+		{
+			name:     "parameters and case",
+			content:  content("Application/JSON; charset=utf-8", "application/json", "text/*"),
+			expected: []string{"application/json", "text/*"},
+		},
+		{
+			name:    "any media type",
+			content: content("application/json", "*/*"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := mediaTypeRanges(test.content); !slices.Equal(actual, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
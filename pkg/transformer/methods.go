@@ -0,0 +1,207 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"fmt"
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"strings"
+)
+
+// HeadRequestVariable is the flow variable set when a HEAD request is routed to a GET flow
+const HeadRequestVariable = "spec2proxy.head"
+
+// GroupFlowsByPath returns the OAS paths in the order they were declared, and the flows for each one of them
+func GroupFlowsByPath(flows []*v1.ConditionalFlow) ([]string, map[string][]*v1.ConditionalFlow) {
+	var paths []string
+	flowsByPath := make(map[string][]*v1.ConditionalFlow)
+	for _, flow := range flows {
+		if _, found := flowsByPath[flow.Path]; !found {
+			paths = append(paths, flow.Path)
+		}
+		flowsByPath[flow.Path] = append(flowsByPath[flow.Path], flow)
+	}
+	return paths, flowsByPath
+}
+
+// AllowedVerbs returns the verbs accepted for a path, given the flows for the path
+func AllowedVerbs(flows []*v1.ConditionalFlow, options *Options) []string {
+	verbs := flowVerbs(flows)
+
+	if options.HeadToGet && containsVerb(verbs, "GET") && !containsVerb(verbs, "HEAD") {
+		verbs = append(verbs, "HEAD")
+	}
+
+	if options.AutoOptions && !containsVerb(verbs, "OPTIONS") {
+		verbs = append(verbs, "OPTIONS")
+	}
+
+	return verbs
+}
+
+// AddMethodHandling adds the flows for HEAD and OPTIONS requests, and for responding
+// with 405 Method Not Allowed when a known path is requested with an undeclared verb.
+func AddMethodHandling(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, options *Options) error {
+	var err error
+	paths, flowsByPath := GroupFlowsByPath(proxyEndpoint.Flows)
+
+	if options.HeadToGet {
+		if err = addHeadToGet(apiProxy, flowsByPath, options); err != nil {
+			return err
+		}
+	}
+
+	var newFlows []*v1.ConditionalFlow
+	for _, path := range paths {
+		pathFlows := flowsByPath[path]
+		verbs := AllowedVerbs(pathFlows, options)
+		pathCondition := BuildPathCondition(path, pathFlows[0].Parameters, options)
+
+		if options.AutoOptions && !containsVerb(flowVerbs(pathFlows), "OPTIONS") {
+			var optionsFlow *v1.ConditionalFlow
+			if optionsFlow, err = buildOptionsFlow(apiProxy, proxyEndpoint, path, pathCondition, verbs); err != nil {
+				return err
+			}
+			newFlows = append(newFlows, optionsFlow)
+		}
+
+		if options.MethodNotAllowed {
+			var methodNotAllowedFlow *v1.ConditionalFlow
+			if methodNotAllowedFlow, err = buildMethodNotAllowedFlow(apiProxy, proxyEndpoint, path, pathCondition, verbs); err != nil {
+				return err
+			}
+			newFlows = append(newFlows, methodNotAllowedFlow)
+		}
+	}
+
+	//these flows must come after all the operation flows
+	proxyEndpoint.Flows = append(proxyEndpoint.Flows, newFlows...)
+	return nil
+}
+
+func addHeadToGet(apiProxy *v1.APIProxy, flowsByPath map[string][]*v1.ConditionalFlow, options *Options) error {
+	requestPolicy := NewAssignMessagePolicy("AM-HeadToGet")
+	requestPolicy.AssignMessage.Set = &MessageSet{Verb: "GET"}
+	requestPolicy.AssignMessage.AssignVariable = &AssignVariable{Name: HeadRequestVariable, Value: "true"}
+
+	responsePolicy := NewAssignMessagePolicy("AM-HeadResponse")
+	responsePolicy.AssignMessage.Remove = &MessageRemove{Payload: "true"}
+
+	added := false
+	var err error
+	for _, pathFlows := range flowsByPath {
+		if containsVerb(flowVerbs(pathFlows), "HEAD") {
+			continue
+		}
+
+		for _, flow := range pathFlows {
+			if flow.Verb != "GET" {
+				continue
+			}
+
+			flow.Condition = fmt.Sprintf("(%s) and (request.verb = \"GET\" or request.verb = \"HEAD\")", BuildPathCondition(flow.Path, flow.Parameters, options))
+			flow.Request = append([]*v1.Step{v1.NewStep(requestPolicy.AssignMessage.Name, "request.verb = \"HEAD\"")}, flow.Request...)
+			flow.Response = append(flow.Response, v1.NewStep(responsePolicy.AssignMessage.Name, fmt.Sprintf("%s = true", HeadRequestVariable)))
+			added = true
+		}
+	}
+
+	if !added {
+		return nil
+	}
+
+	if _, err = AddPolicy(apiProxy, requestPolicy); err != nil {
+		return err
+	}
+
+	if _, err = AddPolicy(apiProxy, responsePolicy); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func buildOptionsFlow(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, path string, pathCondition string, verbs []string) (*v1.ConditionalFlow, error) {
+	var err error
+	var noRoutePolicyName string
+	if noRoutePolicyName, err = AddNoRoute(apiProxy, proxyEndpoint); err != nil {
+		return nil, err
+	}
+
+	policy := NewAssignMessagePolicy(fmt.Sprintf("AM-Options-%s", PathId(proxyEndpoint, path)))
+	policy.AssignMessage.AssignTo = &AssignTo{CreateNew: "false", Transport: "http", Type: "response"}
+	policy.AssignMessage.Set = &MessageSet{
+		Headers:      []*Header{NewHeader("Allow", strings.Join(verbs, ", "))},
+		StatusCode:   "204",
+		ReasonPhrase: "No Content",
+	}
+
+	if _, err = AddPolicy(apiProxy, policy); err != nil {
+		return nil, err
+	}
+
+	//the flow has no verb, even though it only handles OPTIONS requests, since it is not an operation (see operationFlows)
+	return &v1.ConditionalFlow{
+		Name:        fmt.Sprintf("options-%s", PathId(proxyEndpoint, path)),
+		Description: fmt.Sprintf("Responds to OPTIONS requests for %s", DisplayPath(proxyEndpoint, path)),
+		Condition:   fmt.Sprintf("(%s) and (request.verb = \"OPTIONS\")", pathCondition),
+		Request:     []*v1.Step{v1.NewStep(noRoutePolicyName, "true")},
+		Response:    []*v1.Step{v1.NewStep(policy.AssignMessage.Name, "true")},
+		Extensions:  map[string]*v1.Extension{},
+		Path:        path,
+	}, nil
+}
+
+func buildMethodNotAllowedFlow(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, path string, pathCondition string, verbs []string) (*v1.ConditionalFlow, error) {
+	policy := NewRaiseFaultPolicy(fmt.Sprintf("RF-HTTP405-%s", PathId(proxyEndpoint, path)), "405", "Method Not Allowed")
+	policy.RaiseFault.FaultResponse.Set.Headers = []*Header{NewHeader("Allow", strings.Join(verbs, ", "))}
+
+	var err error
+	if _, err = AddPolicy(apiProxy, policy); err != nil {
+		return nil, err
+	}
+
+	var verbConditions []string
+	for _, verb := range verbs {
+		verbConditions = append(verbConditions, fmt.Sprintf("request.verb = \"%s\"", verb))
+	}
+
+	return &v1.ConditionalFlow{
+		Name:        fmt.Sprintf("method-not-allowed-%s", PathId(proxyEndpoint, path)),
+		Description: fmt.Sprintf("Responds HTTP 405 for undeclared verbs on %s", DisplayPath(proxyEndpoint, path)),
+		Condition:   fmt.Sprintf("(%s) and not (%s)", pathCondition, strings.Join(verbConditions, " or ")),
+		Request:     []*v1.Step{v1.NewStep(policy.RaiseFault.Name, "true")},
+		Response:    []*v1.Step{},
+		Extensions:  map[string]*v1.Extension{},
+		Path:        path,
+	}, nil
+}
+
+func flowVerbs(flows []*v1.ConditionalFlow) []string {
+	var verbs []string
+	for _, flow := range flows {
+		verbs = append(verbs, flow.Verb)
+	}
+	return verbs
+}
+
+func containsVerb(verbs []string, verb string) bool {
+	for _, v := range verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
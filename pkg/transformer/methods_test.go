@@ -0,0 +1,68 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"testing"
+)
+
+// TestAddMethodHandling checks the generated OPTIONS and HTTP 405 flows, which must not be taken for operations
+func TestAddMethodHandling(t *testing.T) {
+	apiProxy := &v1.APIProxy{Name: "test"}
+	proxyEndpoint := &v1.ProxyEndpoint{
+		Name:     "default",
+		BasePath: "/v1",
+		Flows: []*v1.ConditionalFlow{
+			{Name: "getPet", Verb: "GET", Path: "/pets/{id}"},
+			{Name: "deletePet", Verb: "DELETE", Path: "/pets/{id}/"},
+		},
+	}
+
+	options := NewOptions()
+	options.AutoOptions = true
+	options.MethodNotAllowed = true
+	if err := AddMethodHandling(apiProxy, proxyEndpoint, options); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"getPet":                           "",
+		"deletePet":                        "",
+		"options-v1-pets-id":               "Responds to OPTIONS requests for /v1/pets/{id}",
+		"method-not-allowed-v1-pets-id":    "Responds HTTP 405 for undeclared verbs on /v1/pets/{id}",
+		"options-v1-pets-id--2":            "Responds to OPTIONS requests for /v1/pets/{id}/",
+		"method-not-allowed-v1-pets-id--2": "Responds HTTP 405 for undeclared verbs on /v1/pets/{id}/",
+	}
+
+	if len(proxyEndpoint.Flows) != len(expected) {
+		t.Fatalf("expected %d flows, got %d", len(expected), len(proxyEndpoint.Flows))
+	}
+
+	for _, flow := range proxyEndpoint.Flows {
+		description, found := expected[flow.Name]
+		if !found {
+			t.Fatalf("unexpected flow '%s'", flow.Name)
+		}
+
+		if flow.Description != description {
+			t.Errorf("expected flow '%s' to have the description '%s', got '%s'", flow.Name, description, flow.Description)
+		}
+	}
+
+	if operations := operationFlows(proxyEndpoint.Flows); len(operations) != 2 {
+		t.Errorf("expected the generated flows not to be operations, got %d operations", len(operations))
+	}
+}
@@ -0,0 +1,422 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/go-errors/errors"
+	"github.com/gosimple/slug"
+	v1 "github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"github.com/pb33f/libopenapi/datamodel/high/base"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// SetupMockRouteRules adds the proxy endpoint to the API Proxy without a target. Every request uses the noroute rule.
+func SetupMockRouteRules(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint) {
+	proxyEndpoint.RouteRules = append(proxyEndpoint.RouteRules, &v1.RouteRule{Name: "noroute"})
+
+	apiProxy.ProxyEndpoints = append(apiProxy.ProxyEndpoints, proxyEndpoint)
+	apiProxy.Resources = []*v1.Resource{}
+}
+
+// AddMockResponses adds the AssignMessage policies that respond with the examples from the spec, when the Mock option is set.
+//
+// Each operation responds with its first 2xx response (or its first response, if there is no 2xx response). The example
+// comes from the spec, or it is synthesized from the schema. The "Prefer" request header selects a different response,
+// either by status code (e.g. "Prefer: code=404"), or by the name of an example (e.g. "Prefer: example=empty").
+func AddMockResponses(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, options *Options) error {
+	if !options.Mock {
+		return nil
+	}
+
+	var err error
+	for _, flow := range operationFlows(proxyEndpoint.Flows) {
+		var steps []*v1.Step
+		var defaultStep *v1.Step
+		var overrideConditions []string
+		defaultResponse := mockDefaultResponse(flow.Responses)
+
+		for _, response := range flow.Responses {
+			mediaType := mockMediaType(response.Content)
+
+			var policy *AssignMessagePolicy
+			if response == defaultResponse {
+				if policy, err = addMockPolicy(apiProxy, fmt.Sprintf("AM-Mock-%s", FlowId(flow)), response, mediaType, nil); err != nil {
+					return err
+				}
+				defaultStep = v1.NewStep(policy.AssignMessage.Name, "true")
+				steps = append(steps, defaultStep)
+			} else if response.StatusCode != "default" {
+				name := fmt.Sprintf("AM-Mock-%s-%s", FlowId(flow), response.StatusCode)
+				if policy, err = addMockPolicy(apiProxy, name, response, mediaType, nil); err != nil {
+					return err
+				}
+				overrideConditions = append(overrideConditions, preferCondition("code", response.StatusCode))
+				steps = append(steps, v1.NewStep(policy.AssignMessage.Name, preferCondition("code", response.StatusCode)))
+			}
+
+			if mediaType == nil {
+				continue
+			}
+
+			for _, example := range mediaType.Examples {
+				name := fmt.Sprintf("AM-Mock-%s-%s-%s", FlowId(flow), response.StatusCode, slug.Make(example.Name))
+				if policy, err = addMockPolicy(apiProxy, name, response, mediaType, example.Value); err != nil {
+					return err
+				}
+				overrideConditions = append(overrideConditions, preferCondition("example", example.Name))
+				steps = append(steps, v1.NewStep(policy.AssignMessage.Name, preferCondition("example", example.Name)))
+			}
+		}
+
+		//the responses selected with the Prefer header are used instead of the default one, so that the default payload
+		//(and its Content-Type) is not left in responses without content
+		if defaultStep != nil && len(overrideConditions) > 0 {
+			defaultStep.Step.Condition = fmt.Sprintf("not (%s)", strings.Join(overrideConditions, " or "))
+		}
+		flow.Response = append(steps, flow.Response...)
+	}
+
+	return nil
+}
+
+// addMockPolicy adds a policy that sets the response status, and the payload from the given example.
+// If the example is nil, it comes from the media type, or it is synthesized from the schema.
+func addMockPolicy(apiProxy *v1.APIProxy, name string, response *v1.Response, mediaType *v1.MediaType, example *yaml.Node) (*AssignMessagePolicy, error) {
+	statusCode := mockStatusCode(response.StatusCode)
+
+	policy := NewAssignMessagePolicy(name)
+	policy.AssignMessage.AssignTo = &AssignTo{CreateNew: "false", Transport: "http", Type: "response"}
+	policy.AssignMessage.Set = &MessageSet{
+		StatusCode:   statusCode,
+		ReasonPhrase: http.StatusText(mockStatusCodeNumber(statusCode)),
+	}
+
+	if mediaType != nil {
+		if example == nil {
+			example = mediaTypeExample(mediaType)
+		}
+
+		var payload string
+		var err error
+		if payload, err = renderExample(example, mediaType.ContentType); err != nil {
+			return nil, err
+		}
+
+		if payload != "" {
+			policy.AssignMessage.Set.Payload = &Payload{
+				ContentType:    mediaType.ContentType,
+				VariablePrefix: "@",
+				VariableSuffix: "#",
+				Value:          escapeXML(payload),
+			}
+		}
+	}
+
+	if _, err := AddPolicy(apiProxy, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// preferCondition matches requests with the given preference in the "Prefer" header (e.g. code=404)
+func preferCondition(preference string, value string) string {
+	return fmt.Sprintf(`request.header.Prefer JavaRegex "(?i)(.*[ ,;])?%s=\"?%s\"?([ ,;].*)?"`, preference, escapeRegexLiteral(value))
+}
+
+// mockDefaultResponse returns the first 2xx response, or the first response if there is no 2xx response
+func mockDefaultResponse(responses []*v1.Response) *v1.Response {
+	for _, response := range responses {
+		if strings.HasPrefix(response.StatusCode, "2") {
+			return response
+		}
+	}
+
+	if len(responses) > 0 {
+		return responses[0]
+	}
+	return nil
+}
+
+// mockMediaType returns the media type used for the mock response, preferring JSON
+func mockMediaType(content []*v1.MediaType) *v1.MediaType {
+	for _, mediaType := range content {
+		if strings.Contains(mediaType.ContentType, "json") {
+			return mediaType
+		}
+	}
+
+	if len(content) > 0 {
+		return content[0]
+	}
+	return nil
+}
+
+// mockStatusCode converts the response key from the spec into a status code (e.g. 4XX becomes 400, and default becomes 200)
+func mockStatusCode(statusCode string) string {
+	if statusCode == "default" {
+		return "200"
+	}
+	return strings.NewReplacer("X", "0", "x", "0").Replace(statusCode)
+}
+
+func mockStatusCodeNumber(statusCode string) int {
+	var number int
+	_, _ = fmt.Sscanf(statusCode, "%d", &number)
+	return number
+}
+
+// mediaTypeExample returns the example of the media type, its first named example, or an example synthesized from the schema
+func mediaTypeExample(mediaType *v1.MediaType) *yaml.Node {
+	if mediaType.Example != nil {
+		return mediaType.Example
+	}
+
+	for _, example := range mediaType.Examples {
+		if example.Value != nil {
+			return example.Value
+		}
+	}
+
+	if mediaType.Schema == nil {
+		return nil
+	}
+
+	return SynthesizeExample(mediaType.Schema)
+}
+
+// SynthesizeExample builds an example value from the schema, using the examples, defaults and enums within it when present
+func SynthesizeExample(schema *base.Schema) *yaml.Node {
+	return synthesizeExample(schema, nil)
+}
+
+func synthesizeExample(schema *base.Schema, refs []string) *yaml.Node {
+	if schema == nil {
+		return scalarNode("!!null", "null")
+	}
+
+	if schema.Example != nil {
+		return schema.Example
+	}
+
+	if len(schema.Examples) > 0 {
+		return schema.Examples[0]
+	}
+
+	if schema.Default != nil {
+		return schema.Default
+	}
+
+	if len(schema.Enum) > 0 {
+		return schema.Enum[0]
+	}
+
+	if len(schema.OneOf) > 0 {
+		return synthesizeProxyExample(schema.OneOf[0], refs)
+	}
+
+	if len(schema.AnyOf) > 0 {
+		return synthesizeProxyExample(schema.AnyOf[0], refs)
+	}
+
+	if len(schema.AllOf) > 0 {
+		//the properties of all the sub-schemas are merged into a single object
+		result := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, subSchema := range schema.AllOf {
+			if node := synthesizeProxyExample(subSchema, refs); node.Kind == yaml.MappingNode {
+				result.Content = append(result.Content, node.Content...)
+			}
+		}
+		return result
+	}
+
+	schemaType := ""
+	for _, t := range schema.Type {
+		if t != "null" {
+			schemaType = t
+			break
+		}
+	}
+
+	if schemaType == "" && schema.Properties != nil {
+		schemaType = "object"
+	}
+
+	switch schemaType {
+	case "object":
+		result := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for elem := schema.Properties.First(); elem != nil; elem = elem.Next() {
+			result.Content = append(result.Content, scalarNode("!!str", elem.Key()), synthesizeProxyExample(elem.Value(), refs))
+		}
+		return result
+	case "array":
+		result := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		if schema.Items != nil && schema.Items.IsA() {
+			result.Content = append(result.Content, synthesizeProxyExample(schema.Items.A, refs))
+		}
+		return result
+	case "string":
+		return scalarNode("!!str", stringExample(schema.Format))
+	case "integer":
+		if schema.Minimum != nil {
+			return scalarNode("!!int", fmt.Sprintf("%d", int64(*schema.Minimum)))
+		}
+		return scalarNode("!!int", "0")
+	case "number":
+		if schema.Minimum != nil {
+			return scalarNode("!!float", fmt.Sprintf("%v", *schema.Minimum))
+		}
+		return scalarNode("!!float", "0.0")
+	case "boolean":
+		return scalarNode("!!bool", "true")
+	default:
+		return scalarNode("!!null", "null")
+	}
+}
+
+// synthesizeProxyExample builds an example from a schema proxy. Recursive references are replaced with null.
+func synthesizeProxyExample(proxy *base.SchemaProxy, refs []string) *yaml.Node {
+	if proxy == nil {
+		return scalarNode("!!null", "null")
+	}
+
+	if proxy.IsReference() {
+		if slices.Contains(refs, proxy.GetReference()) {
+			return scalarNode("!!null", "null")
+		}
+		refs = append(slices.Clone(refs), proxy.GetReference())
+	}
+
+	return synthesizeExample(proxy.Schema(), refs)
+}
+
+func stringExample(format string) string {
+	switch format {
+	case "date":
+		return "2024-01-01"
+	case "date-time":
+		return "2024-01-01T00:00:00Z"
+	case "time":
+		return "00:00:00"
+	case "email":
+		return "user@example.com"
+	case "uuid":
+		return "3fa85f64-5717-4562-b3fc-2c963f66afa6"
+	case "uri", "url":
+		return "https://example.com"
+	case "hostname":
+		return "example.com"
+	case "ipv4":
+		return "192.0.2.1"
+	case "ipv6":
+		return "2001:db8::1"
+	case "byte":
+		return "ZXhhbXBsZQ=="
+	default:
+		return "string"
+	}
+}
+
+func scalarNode(tag string, value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+}
+
+// renderExample renders the example as the payload for the content type. String examples for non-JSON
+// content types are used as-is, and everything else is rendered as JSON.
+func renderExample(example *yaml.Node, contentType string) (string, error) {
+	if example == nil {
+		return "", nil
+	}
+
+	if example.Kind == yaml.ScalarNode && example.ShortTag() == "!!str" && !strings.Contains(contentType, "json") {
+		return example.Value, nil
+	}
+
+	var buffer bytes.Buffer
+	if err := nodeToJSON(example, &buffer); err != nil {
+		return "", err
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, buffer.Bytes(), "", "  "); err != nil {
+		return "", errors.New(err)
+	}
+	return indented.String(), nil
+}
+
+// nodeToJSON writes the YAML node as JSON, keeping the order of the keys
+func nodeToJSON(node *yaml.Node, buffer *bytes.Buffer) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			buffer.WriteString("null")
+			return nil
+		}
+		return nodeToJSON(node.Content[0], buffer)
+	case yaml.AliasNode:
+		return nodeToJSON(node.Alias, buffer)
+	case yaml.MappingNode:
+		buffer.WriteString("{")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buffer.WriteString(",")
+			}
+			if err := writeJSONValue(node.Content[i].Value, buffer); err != nil {
+				return err
+			}
+			buffer.WriteString(":")
+			if err := nodeToJSON(node.Content[i+1], buffer); err != nil {
+				return err
+			}
+		}
+		buffer.WriteString("}")
+		return nil
+	case yaml.SequenceNode:
+		buffer.WriteString("[")
+		for i, item := range node.Content {
+			if i > 0 {
+				buffer.WriteString(",")
+			}
+			if err := nodeToJSON(item, buffer); err != nil {
+				return err
+			}
+		}
+		buffer.WriteString("]")
+		return nil
+	default:
+		var value any
+		if err := node.Decode(&value); err != nil {
+			return errors.New(err)
+		}
+		return writeJSONValue(value, buffer)
+	}
+}
+
+// writeJSONValue writes a scalar value as JSON, without escaping HTML characters
+func writeJSONValue(value any, buffer *bytes.Buffer) error {
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return errors.New(err)
+	}
+	//the encoder always adds a newline
+	buffer.Truncate(buffer.Len() - 1)
+	return nil
+}
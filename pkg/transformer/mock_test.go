@@ -0,0 +1,68 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"github.com/pb33f/libopenapi/datamodel/high/base"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestAddMockResponsesPrefer checks that the responses selected with the Prefer header are used instead of the default one,
+// so that a response without content does not get the payload of the default response
+func TestAddMockResponsesPrefer(t *testing.T) {
+	apiProxy := &v1.APIProxy{}
+	flow := &v1.ConditionalFlow{
+		Name: "showPet",
+		Verb: "GET",
+		Responses: []*v1.Response{
+			{StatusCode: "200", Content: []*v1.MediaType{{ContentType: "application/json", Schema: &base.Schema{Type: []string{"string"}}}}},
+			{StatusCode: "404"},
+		},
+	}
+	proxyEndpoint := &v1.ProxyEndpoint{Flows: []*v1.ConditionalFlow{flow}}
+
+	options := NewOptions()
+	options.Mock = true
+	if err := AddMockResponses(apiProxy, proxyEndpoint, options); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(flow.Response) != 2 {
+		t.Fatalf("expected 2 response steps, got %d", len(flow.Response))
+	}
+
+	defaultStep, overrideStep := flow.Response[0].Step, flow.Response[1].Step
+	if defaultStep.Name != "AM-Mock-showPet" || overrideStep.Name != "AM-Mock-showPet-404" {
+		t.Fatalf("unexpected steps '%s' and '%s'", defaultStep.Name, overrideStep.Name)
+	}
+
+	//the conditions are Java regexes, which are compatible with Go regexes for these patterns
+	preferRegex := regexp.MustCompile(`JavaRegex "(.*)"`)
+	overrideRegex := regexp.MustCompile("^(?:" + preferRegex.FindStringSubmatch(overrideStep.Condition)[1] + ")$")
+	defaultRegex := regexp.MustCompile("^(?:" + preferRegex.FindStringSubmatch(defaultStep.Condition)[1] + ")$")
+
+	if !strings.HasPrefix(defaultStep.Condition, "not (") {
+		t.Fatalf("expected the default step to run only without an override, got '%s'", defaultStep.Condition)
+	}
+
+	for prefer, override := range map[string]bool{"code=404": true, "return=minimal, code=404": true, "code=4040": false, "": false} {
+		if overrideRegex.MatchString(prefer) != override || defaultRegex.MatchString(prefer) != override {
+			t.Errorf("expected 'Prefer: %s' to select the override: %v", prefer, override)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-errors/errors"
+	v1 "github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"gopkg.in/yaml.v3"
+	"strings"
+)
+
+// OASResourceType is the type of the API Proxy resources that hold OpenAPI specs
+const OASResourceType = "oas"
+
+type OASValidationPolicy struct {
+	OASValidation struct {
+		Name        string `yaml:".name"`
+		DisplayName string `yaml:"DisplayName"`
+		OASResource string `yaml:"OASResource"`
+		Source      string `yaml:"Source"`
+		Options     struct {
+			ValidateMessageBody        string `yaml:"ValidateMessageBody"`
+			AllowUnspecifiedParameters struct {
+				Header string `yaml:"Header"`
+				Query  string `yaml:"Query"`
+				Cookie string `yaml:"Cookie"`
+			} `yaml:"AllowUnspecifiedParameters"`
+		} `yaml:"Options"`
+	} `yaml:"OASValidation"`
+}
+
+// NeedsOASResource returns true if the spec must be bundled with the API Proxy, either because of the options,
+// or because an operation has the "x-Apigee-OASValidation" extension.
+func NeedsOASResource(apiProxy *v1.APIProxy, options *Options) (bool, error) {
+	if options.OASResource || options.OASValidation {
+		return true, nil
+	}
+
+	for _, proxyEndpoint := range apiProxy.ProxyEndpoints {
+		for _, flow := range proxyEndpoint.Flows {
+			if enabled, err := oasValidationEnabled(flow); err != nil {
+				return false, err
+			} else if enabled != nil && *enabled {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// AddOASResource adds the rendered (fully dereferenced) spec as a resource of the API Proxy.
+// The "x-Apigee-*" extensions are removed, since they are only meant for the generator.
+func AddOASResource(apiProxy *v1.APIProxy, rendered []byte) error {
+	var err error
+	document := &yaml.Node{}
+	if err = yaml.Unmarshal(rendered, document); err != nil {
+		return errors.New(err)
+	}
+
+	cleanOASNode(document)
+
+	var content bytes.Buffer
+	encoder := yaml.NewEncoder(&content)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(document); err != nil {
+		return errors.New(err)
+	}
+
+	apiProxy.Resources = append(apiProxy.Resources, &v1.Resource{
+		ResourceType: OASResourceType,
+		ResourceFile: fmt.Sprintf("%s.yaml", apiProxy.Name),
+		Content:      content.Bytes(),
+	})
+	return nil
+}
+
+// AddOASValidation adds an OASValidation policy that validates requests against the bundled spec.
+// With the OASValidation option, the policy is added to the flows of all operations, except the ones with "x-Apigee-OASValidation: false",
+// and to the catch-all flow for the requests that do not match any operation.
+// Otherwise, the policy is added only to the flows of operations with "x-Apigee-OASValidation: true".
+func AddOASValidation(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, options *Options) error {
+	var resource *v1.Resource
+	for _, r := range apiProxy.Resources {
+		if r.ResourceType == OASResourceType {
+			resource = r
+			break
+		}
+	}
+
+	if resource == nil {
+		return nil
+	}
+
+	policy := OASValidationPolicy{}
+	policy.OASValidation.Name = "OAS-Validate"
+	policy.OASValidation.DisplayName = policy.OASValidation.Name
+	policy.OASValidation.OASResource = fmt.Sprintf("%s://%s", resource.ResourceType, resource.ResourceFile)
+	policy.OASValidation.Source = "request"
+	policy.OASValidation.Options.ValidateMessageBody = "true"
+	policy.OASValidation.Options.AllowUnspecifiedParameters.Header = "true"
+	policy.OASValidation.Options.AllowUnspecifiedParameters.Query = "true"
+	policy.OASValidation.Options.AllowUnspecifiedParameters.Cookie = "true"
+
+	var err error
+	var added bool
+	exempt := map[*v1.ConditionalFlow]bool{}
+	for _, flow := range operationFlows(proxyEndpoint.Flows) {
+		var enabled *bool
+		if enabled, err = oasValidationEnabled(flow); err != nil {
+			return err
+		}
+
+		if enabled == nil {
+			continue
+		}
+
+		if options.OASValidation && !*enabled {
+			exempt[flow] = true
+		} else if !options.OASValidation && *enabled {
+			flow.Request = append(flow.Request, v1.NewStep(policy.OASValidation.Name, "true"))
+			added = true
+		}
+	}
+
+	if options.OASValidation {
+		//the generated flows (e.g. OPTIONS and 405) handle requests that are not in the spec, so they are not validated
+		notValidated := func(flow *v1.ConditionalFlow) bool {
+			return exempt[flow] || RespondsWithinProxy(flow)
+		}
+
+		for _, flow := range DefaultFlows(proxyEndpoint, notValidated) {
+			flow.Request = append(flow.Request, v1.NewStep(policy.OASValidation.Name, "true"))
+		}
+		added = true
+	}
+
+	if !added {
+		return nil
+	}
+
+	if _, err = AddPolicy(apiProxy, policy); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// oasValidationEnabled returns the value of the "x-Apigee-OASValidation" extension, or nil if the flow does not have it
+func oasValidationEnabled(flow *v1.ConditionalFlow) (*bool, error) {
+	extension, found := flow.Extensions["x-Apigee-OASValidation"]
+	if !found || extension.Value == nil {
+		return nil, nil
+	}
+
+	var enabled bool
+	if err := extension.Value.Decode(&enabled); err != nil {
+		return nil, errors.New(err)
+	}
+	return &enabled, nil
+}
+
+// cleanOASNode removes the "x-Apigee-*" extensions, and the explicit float tags added when rendering whole numbers
+func cleanOASNode(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!float" {
+		node.Tag = ""
+	}
+
+	if node.Kind == yaml.MappingNode {
+		var content []*yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if strings.HasPrefix(strings.ToLower(node.Content[i].Value), "x-apigee-") {
+				continue
+			}
+			content = append(content, node.Content[i], node.Content[i+1])
+		}
+		node.Content = content
+	}
+
+	for _, child := range node.Content {
+		cleanOASNode(child)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"github.com/go-errors/errors"
+)
+
+const (
+	// TrailingSlashStrict generates flow conditions that match the spec paths exactly
+	TrailingSlashStrict = "strict"
+
+	// TrailingSlashLenient generates flow conditions that match the spec paths with or without a trailing slash
+	TrailingSlashLenient = "lenient"
+
+	// TrailingSlashRedirect redirects requests with a trailing slash to the same path without it
+	TrailingSlashRedirect = "redirect"
+)
+
+const (
+	// GoogleAuthIDToken authenticates target requests with a Google-signed OIDC ID token (e.g. for Cloud Run)
+	GoogleAuthIDToken = "id-token"
+
+	// GoogleAuthAccessToken authenticates target requests with a Google OAuth access token (e.g. for Google APIs)
+	GoogleAuthAccessToken = "access-token"
+)
+
+// Options controls which spec-derived policies are generated by the transformers
+type Options struct {
+	// PathVariablesPrefix is the prefix used for flow variables extracted from OAS path parameters
+	PathVariablesPrefix string
+
+	// MethodNotAllowed adds flows that respond 405 when a known path is requested with an undeclared verb
+	MethodNotAllowed bool
+
+	// AutoOptions adds flows that respond to OPTIONS requests with the verbs declared for the path
+	AutoOptions bool
+
+	// HeadToGet routes HEAD requests to the GET operation of the same path
+	HeadToGet bool
+
+	// TrailingSlash controls how paths with a trailing slash are handled (strict, lenient, or redirect)
+	TrailingSlash string
+
+	// SplitByTag moves the operations of each OAS tag into their own ProxyEndpoint
+	SplitByTag bool
+
+	// Security generates policies that enforce the spec security requirements
+	Security bool
+
+	// ThreatProtection adds JSON and XML threat protection policies derived from the request body schemas
+	ThreatProtection bool
+
+	// ParameterValidation rejects query and header parameters that do not match the pattern or enum declared in the spec
+	ParameterValidation bool
+
+	// MediaTypeValidation rejects requests with a Content-Type (415) or Accept (406) header that does not match the media types in the spec
+	MediaTypeValidation bool
+
+	// InjectionProtection rejects requests containing common injection patterns in the path and parameters
+	InjectionProtection bool
+
+	// ParameterDefaults adds the spec default values of the query and header parameters that are missing from the request
+	ParameterDefaults bool
+
+	// EnforceSunset makes deprecated operations respond 410 after the sunset date from their "x-sunset" extension
+	EnforceSunset bool
+
+	// OASResource bundles a dereferenced copy of the spec with the API Proxy, as an "oas" resource
+	OASResource bool
+
+	// OASValidation validates requests against the bundled spec with an OASValidation policy in the PreFlow
+	OASValidation bool
+
+	// Mock generates a proxy without target, that responds with the examples from the spec
+	Mock bool
+
+	// APIProducts generates API product definitions (management API JSON) for the operations of the spec
+	APIProducts bool
+
+	// InsecureTargetTLS turns off certificate validation for HTTPS targets
+	InsecureTargetTLS bool
+
+	// GoogleAuth authenticates target requests with a Google token (empty, id-token, or access-token)
+	GoogleAuth string
+
+	// GoogleAudience is the audience of Google ID tokens. It defaults to the target URL.
+	GoogleAudience string
+
+	// GoogleScopes are the scopes of Google access tokens
+	GoogleScopes []string
+}
+
+func NewOptions() *Options {
+	return &Options{
+		PathVariablesPrefix: "oas.path",
+		MethodNotAllowed:    true,
+		AutoOptions:         true,
+		HeadToGet:           false,
+		TrailingSlash:       TrailingSlashStrict,
+		Security:            true,
+		ThreatProtection:    true,
+		ParameterValidation: true,
+		MediaTypeValidation: true,
+		InjectionProtection: false,
+		ParameterDefaults:   false,
+		EnforceSunset:       false,
+		OASResource:         false,
+		OASValidation:       false,
+		APIProducts:         false,
+		Mock:                false,
+		InsecureTargetTLS:   false,
+		GoogleAuth:          "",
+		GoogleScopes:        []string{"https://www.googleapis.com/auth/cloud-platform"},
+	}
+}
+
+// Validate checks that the options have supported values
+func (o *Options) Validate() error {
+	switch o.TrailingSlash {
+	case TrailingSlashStrict, TrailingSlashLenient, TrailingSlashRedirect:
+	default:
+		return errors.Errorf("trailing slash mode '%s' is not supported", o.TrailingSlash)
+	}
+
+	switch o.GoogleAuth {
+	case "", GoogleAuthIDToken, GoogleAuthAccessToken:
+	default:
+		return errors.Errorf("google auth type '%s' is not supported", o.GoogleAuth)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"fmt"
+	"github.com/go-errors/errors"
+	v1 "github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"gopkg.in/yaml.v3"
+)
+
+// AddParameterDefaults adds AssignMessage policies that set the spec default values of the query and header parameters
+// that are missing from the request, so that the target gets them. Each parameter gets its own policy, with a step that runs
+// only when the parameter is missing. Only parameters with a scalar default value are supported.
+// This is turned on with the ParameterDefaults option, or with the "x-Apigee-ParameterDefaults" extension (at the top level
+// of the spec, or within an operation).
+func AddParameterDefaults(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, options *Options) error {
+	var err error
+	for _, flow := range operationFlows(proxyEndpoint.Flows) {
+		var enabled bool
+		if enabled, err = parameterDefaultsEnabled(apiProxy, flow, options); err != nil {
+			return err
+		}
+
+		if !enabled {
+			continue
+		}
+
+		for _, param := range flow.Parameters {
+			if param.Schema == nil || param.Schema.Default == nil || param.Schema.Default.Kind != yaml.ScalarNode {
+				continue
+			}
+
+			policy := NewAssignMessagePolicy(fmt.Sprintf("AM-Default-%s-%s", FlowId(flow), unsafeNameCharsRegex.ReplaceAllString(param.Name, "-")))
+			value := escapeXML(param.Schema.Default.Value)
+
+			var variable string
+			switch param.In {
+			case "query":
+				variable = fmt.Sprintf("request.queryparam.%s", param.Name)
+				policy.AssignMessage.Add = &MessageAdd{QueryParams: []*QueryParam{NewQueryParam(param.Name, value)}}
+			case "header":
+				variable = fmt.Sprintf("request.header.%s", param.Name)
+				policy.AssignMessage.Add = &MessageAdd{Headers: []*Header{NewHeader(param.Name, value)}}
+			default:
+				continue
+			}
+
+			if _, err = AddPolicy(apiProxy, policy); err != nil {
+				return err
+			}
+
+			flow.Request = append(flow.Request, v1.NewStep(policy.AssignMessage.Name, fmt.Sprintf("%s = null", variable)))
+		}
+	}
+
+	return nil
+}
+
+func parameterDefaultsEnabled(apiProxy *v1.APIProxy, flow *v1.ConditionalFlow, options *Options) (bool, error) {
+	enabled := options.ParameterDefaults
+	for _, extensions := range []map[string]*v1.Extension{apiProxy.Extensions, flow.Extensions} {
+		if extension, found := extensions["x-Apigee-ParameterDefaults"]; found && extension.Value != nil {
+			if err := extension.Value.Decode(&enabled); err != nil {
+				return false, errors.New(err)
+			}
+		}
+	}
+	return enabled, nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"fmt"
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"regexp"
+)
+
+type URIPathPattern struct {
+	Pattern struct {
+		IgnoreCase string `yaml:".ignoreCase"`
+		Value      string `yaml:".@"`
+	} `yaml:"Pattern"`
+}
+
+type ExtractVariablesPolicy struct {
+	ExtractVariables struct {
+		Name                      string            `yaml:".name"`
+		DisplayName               string            `yaml:"DisplayName"`
+		Source                    string            `yaml:"Source"`
+		VariablePrefix            string            `yaml:"VariablePrefix"`
+		URIPath                   []*URIPathPattern `yaml:"URIPath"`
+		IgnoreUnresolvedVariables string            `yaml:"IgnoreUnresolvedVariables"`
+	} `yaml:"ExtractVariables"`
+}
+
+var pathParamRegex = regexp.MustCompile(`{[^}]*}`)
+
+// HasPathParams returns true if the OAS path contains templated parameters
+func HasPathParams(oasPath string) bool {
+	return pathParamRegex.MatchString(oasPath)
+}
+
+// AddPathVariables generates an ExtractVariables policy for each templated operation,
+// so that the OAS path parameters are available as flow variables (e.g. oas.path.petId)
+func AddPathVariables(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, options *Options) error {
+	if options.PathVariablesPrefix == "" {
+		return nil
+	}
+
+	var err error
+	for _, flow := range proxyEndpoint.Flows {
+		if !HasPathParams(flow.Path) {
+			continue
+		}
+
+		pattern := &URIPathPattern{}
+		pattern.Pattern.IgnoreCase = "false"
+		pattern.Pattern.Value = flow.Path
+
+		policy := ExtractVariablesPolicy{}
+		policy.ExtractVariables.Name = fmt.Sprintf("EV-PathParams-%s", FlowId(flow))
+		policy.ExtractVariables.DisplayName = policy.ExtractVariables.Name
+		policy.ExtractVariables.Source = "request"
+		policy.ExtractVariables.VariablePrefix = options.PathVariablesPrefix
+		policy.ExtractVariables.URIPath = []*URIPathPattern{pattern}
+		policy.ExtractVariables.IgnoreUnresolvedVariables = "true"
+
+		if _, err = AddPolicy(apiProxy, policy); err != nil {
+			return err
+		}
+
+		//extraction must happen before any other step in the flow
+		flow.Request = append([]*v1.Step{v1.NewStep(policy.ExtractVariables.Name, "true")}, flow.Request...)
+	}
+
+	return nil
+}
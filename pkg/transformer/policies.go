@@ -0,0 +1,383 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-errors/errors"
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"gopkg.in/yaml.v3"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// GeneratePolicies adds the policies and steps derived from the spec to the API Proxy model
+func GeneratePolicies(apiProxy *v1.APIProxy, options *Options) error {
+	if options == nil {
+		options = NewOptions()
+	}
+
+	var err error
+	for _, proxyEndpoint := range apiProxy.ProxyEndpoints {
+		if err = AddPathVariables(apiProxy, proxyEndpoint, options); err != nil {
+			return err
+		}
+
+		if err = AddThreatProtection(apiProxy, proxyEndpoint, options); err != nil {
+			return err
+		}
+
+		if err = AddRegexProtection(apiProxy, proxyEndpoint, options); err != nil {
+			return err
+		}
+
+		if err = AddMediaTypeValidation(apiProxy, proxyEndpoint, options); err != nil {
+			return err
+		}
+
+		if err = AddMethodHandling(apiProxy, proxyEndpoint, options); err != nil {
+			return err
+		}
+
+		if err = AddTrailingSlashRedirect(apiProxy, proxyEndpoint, options); err != nil {
+			return err
+		}
+
+		if err = AddPathParameterValidation(apiProxy, proxyEndpoint, options); err != nil {
+			return err
+		}
+
+		if err = AddSecurity(apiProxy, proxyEndpoint, options); err != nil {
+			return err
+		}
+
+		if err = AddRateLimits(apiProxy, proxyEndpoint, options); err != nil {
+			return err
+		}
+
+		if err = AddQuotas(apiProxy, proxyEndpoint, options); err != nil {
+			return err
+		}
+
+		if err = AddOASValidation(apiProxy, proxyEndpoint, options); err != nil {
+			return err
+		}
+
+		if err = AddParameterDefaults(apiProxy, proxyEndpoint, options); err != nil {
+			return err
+		}
+
+		if err = AddResponseCache(apiProxy, proxyEndpoint, options); err != nil {
+			return err
+		}
+
+		if err = AddResponseFilter(apiProxy, proxyEndpoint, options); err != nil {
+			return err
+		}
+
+		if err = AddMockResponses(apiProxy, proxyEndpoint, options); err != nil {
+			return err
+		}
+
+		if err = AddDeprecation(apiProxy, proxyEndpoint, options); err != nil {
+			return err
+		}
+
+		//must be last, so that preflight requests skip all the other PreFlow steps
+		if err = AddCORS(apiProxy, proxyEndpoint, options); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddPolicy converts the given policy struct into a policy model, and adds it to the API Proxy.
+// If a policy with the same name and content already exists, the existing one is kept.
+// It is an error to add a different policy with the name of an existing one.
+func AddPolicy(apiProxy *v1.APIProxy, policy any) (*v1.Policy, error) {
+	var err error
+	policyModel := &v1.Policy{}
+	if err = v1.UnmarshalPolicy(policy, policyModel); err != nil {
+		return nil, err
+	}
+
+	for _, existing := range apiProxy.Policies {
+		if existing.Name() != policyModel.Name() {
+			continue
+		}
+
+		var existingYAML, policyYAML []byte
+		if existingYAML, err = yaml.Marshal(existing.Data); err != nil {
+			return nil, errors.New(err)
+		}
+		if policyYAML, err = yaml.Marshal(policyModel.Data); err != nil {
+			return nil, errors.New(err)
+		}
+		if !bytes.Equal(existingYAML, policyYAML) {
+			return nil, errors.Errorf("policy '%s' is generated with different contents", policyModel.Name())
+		}
+		return existing, nil
+	}
+
+	apiProxy.Policies = append(apiProxy.Policies, policyModel)
+	return policyModel, nil
+}
+
+// FlowId returns an identifier for the flow that is safe to use within policy names
+func FlowId(flow *v1.ConditionalFlow) string {
+	id := flow.Name
+	if id == "" {
+		id = fmt.Sprintf("%s%s", strings.ToLower(flow.Verb), flow.Path)
+	}
+
+	return strings.Trim(unsafeNameCharsRegex.ReplaceAllString(id, "-"), "-")
+}
+
+var unsafeNameCharsRegex = regexp.MustCompile(`[^A-Za-z0-9_.]+`)
+
+// PathId returns an identifier for the OAS path that is safe to use within policy and flow names.
+// The proxy endpoint base path is included, so that the identifier is unique across proxy endpoints.
+// Distinct paths of the proxy endpoint that have the same identifier (e.g. "/a-b" and "/a/b", or "/pets/{id}" and "/pets/{id}/")
+// are told apart by the order of their operations, with a "--<n>" suffix, which never appears within the identifier itself.
+func PathId(proxyEndpoint *v1.ProxyEndpoint, oasPath string) string {
+	id := pathId(proxyEndpoint.BasePath + oasPath)
+
+	var paths []string
+	for _, flow := range operationFlows(proxyEndpoint.Flows) {
+		if !slices.Contains(paths, flow.Path) && pathId(proxyEndpoint.BasePath+flow.Path) == id {
+			paths = append(paths, flow.Path)
+		}
+	}
+
+	if index := slices.Index(paths, oasPath); index > 0 {
+		return fmt.Sprintf("%s--%d", id, index+1)
+	}
+	return id
+}
+
+func pathId(path string) string {
+	id := strings.Trim(unsafeNameCharsRegex.ReplaceAllString(path, "-"), "-")
+	if id == "" {
+		return "root"
+	}
+	return id
+}
+
+// DisplayPath returns the full path of an OAS path within the proxy endpoint (e.g. for flow descriptions).
+// The OAS path can be empty for the root operation of a split proxy endpoint.
+func DisplayPath(proxyEndpoint *v1.ProxyEndpoint, oasPath string) string {
+	path := strings.TrimSuffix(proxyEndpoint.BasePath, "/") + oasPath
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// CatchAllFlowName is the name of the flow that handles the requests that do not match any other flow
+const CatchAllFlowName = "catch-all"
+
+// RespondsWithinProxy tells whether the flow is one of the generated flows that respond without going to the target
+// (e.g. OPTIONS, HTTP 405, or the trailing slash redirect). The catch-all flow goes to the target.
+func RespondsWithinProxy(flow *v1.ConditionalFlow) bool {
+	return flow.Verb == "" && flow.Name != CatchAllFlowName
+}
+
+// DefaultFlows returns the flows of the proxy endpoint that are not exempt, plus a catch-all flow (added at the end, if missing)
+// for the requests that do not match any other flow.
+// Steps that apply to all the requests, except the ones handled by some flows, are added to these flows instead of the PreFlow,
+// since a request can match the condition of an exempt flow, and still be handled by an earlier flow that overlaps it.
+func DefaultFlows(proxyEndpoint *v1.ProxyEndpoint, exempt func(flow *v1.ConditionalFlow) bool) []*v1.ConditionalFlow {
+	var catchAllFlow *v1.ConditionalFlow
+	for _, flow := range proxyEndpoint.Flows {
+		if flow.Name == CatchAllFlowName && flow.Condition == "" {
+			catchAllFlow = flow
+		}
+	}
+
+	if catchAllFlow == nil {
+		catchAllFlow = &v1.ConditionalFlow{
+			Name:        CatchAllFlowName,
+			Description: "Handles the requests that do not match any other flow",
+			Request:     []*v1.Step{},
+			Response:    []*v1.Step{},
+			Extensions:  map[string]*v1.Extension{},
+		}
+		proxyEndpoint.Flows = append(proxyEndpoint.Flows, catchAllFlow)
+	}
+
+	var result []*v1.ConditionalFlow
+	for _, flow := range proxyEndpoint.Flows {
+		if !exempt(flow) {
+			result = append(result, flow)
+		}
+	}
+	return result
+}
+
+// CloneSteps returns a copy of the steps, so that the same steps can be added to multiple flows
+func CloneSteps(steps []*v1.Step) []*v1.Step {
+	var result []*v1.Step
+	for _, step := range steps {
+		result = append(result, v1.NewStep(step.Step.Name, step.Step.Condition))
+	}
+	return result
+}
+
+type Header struct {
+	Header struct {
+		Name  string `yaml:".name"`
+		Value string `yaml:".@"`
+	} `yaml:"Header"`
+}
+
+func NewHeader(name string, value string) *Header {
+	header := &Header{}
+	header.Header.Name = name
+	header.Header.Value = value
+	return header
+}
+
+type Payload struct {
+	ContentType    string `yaml:".contentType,omitempty"`
+	VariablePrefix string `yaml:".variablePrefix,omitempty"`
+	VariableSuffix string `yaml:".variableSuffix,omitempty"`
+	Value          string `yaml:".@"`
+}
+
+type MessageSet struct {
+	Headers      []*Header `yaml:"Headers,omitempty"`
+	Payload      *Payload  `yaml:"Payload,omitempty"`
+	StatusCode   string    `yaml:"StatusCode,omitempty"`
+	ReasonPhrase string    `yaml:"ReasonPhrase,omitempty"`
+	Verb         string    `yaml:"Verb,omitempty"`
+}
+
+type QueryParam struct {
+	QueryParam struct {
+		Name  string `yaml:".name"`
+		Value string `yaml:".@"`
+	} `yaml:"QueryParam"`
+}
+
+func NewQueryParam(name string, value string) *QueryParam {
+	queryParam := &QueryParam{}
+	queryParam.QueryParam.Name = name
+	queryParam.QueryParam.Value = value
+	return queryParam
+}
+
+type MessageAdd struct {
+	Headers     []*Header     `yaml:"Headers,omitempty"`
+	QueryParams []*QueryParam `yaml:"QueryParams,omitempty"`
+}
+
+type MessageRemove struct {
+	Headers []*Header `yaml:"Headers,omitempty"`
+	Payload string    `yaml:"Payload,omitempty"`
+}
+
+type AssignTo struct {
+	CreateNew string `yaml:".createNew"`
+	Transport string `yaml:".transport"`
+	Type      string `yaml:".type"`
+}
+
+type AssignVariable struct {
+	Name     string `yaml:"Name"`
+	Value    string `yaml:"Value,omitempty"`
+	Ref      string `yaml:"Ref,omitempty"`
+	Template string `yaml:"Template,omitempty"`
+}
+
+type AssignMessagePolicy struct {
+	AssignMessage struct {
+		Name                      string          `yaml:".name"`
+		DisplayName               string          `yaml:"DisplayName"`
+		AssignTo                  *AssignTo       `yaml:"AssignTo,omitempty"`
+		Add                       *MessageAdd     `yaml:"Add,omitempty"`
+		Remove                    *MessageRemove  `yaml:"Remove,omitempty"`
+		Set                       *MessageSet     `yaml:"Set,omitempty"`
+		AssignVariable            *AssignVariable `yaml:"AssignVariable,omitempty"`
+		IgnoreUnresolvedVariables string          `yaml:"IgnoreUnresolvedVariables"`
+	} `yaml:"AssignMessage"`
+}
+
+func NewAssignMessagePolicy(name string) *AssignMessagePolicy {
+	policy := &AssignMessagePolicy{}
+	policy.AssignMessage.Name = name
+	policy.AssignMessage.DisplayName = name
+	policy.AssignMessage.IgnoreUnresolvedVariables = "true"
+	return policy
+}
+
+type RaiseFaultPolicy struct {
+	RaiseFault struct {
+		Name                      string `yaml:".name"`
+		DisplayName               string `yaml:"DisplayName"`
+		IgnoreUnresolvedVariables string `yaml:"IgnoreUnresolvedVariables"`
+		FaultResponse             struct {
+			AssignVariable *AssignVariable `yaml:"AssignVariable,omitempty"`
+			Set            *MessageSet     `yaml:"Set,omitempty"`
+		} `yaml:"FaultResponse"`
+	} `yaml:"RaiseFault"`
+}
+
+func NewRaiseFaultPolicy(name string, statusCode string, reasonPhrase string) *RaiseFaultPolicy {
+	policy := &RaiseFaultPolicy{}
+	policy.RaiseFault.Name = name
+	policy.RaiseFault.DisplayName = name
+	policy.RaiseFault.IgnoreUnresolvedVariables = "true"
+	policy.RaiseFault.FaultResponse.Set = &MessageSet{
+		StatusCode:   statusCode,
+		ReasonPhrase: reasonPhrase,
+	}
+	return policy
+}
+
+// NoRouteVariable is the flow variable that, when set to true, skips routing the request to the target
+const NoRouteVariable = "spec2proxy.noroute"
+
+// AddNoRoute adds a RouteRule without target, that is used when the NoRouteVariable is set.
+// It returns the name of the policy that sets the variable.
+func AddNoRoute(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint) (string, error) {
+	policy := NewAssignMessagePolicy("AM-NoRoute")
+	policy.AssignMessage.AssignVariable = &AssignVariable{
+		Name:  NoRouteVariable,
+		Value: "true",
+	}
+
+	var err error
+	if _, err = AddPolicy(apiProxy, policy); err != nil {
+		return "", err
+	}
+
+	for _, routeRule := range proxyEndpoint.RouteRules {
+		if routeRule.Name == "noroute" {
+			return policy.AssignMessage.Name, nil
+		}
+	}
+
+	//route rules are evaluated in order, so the noroute rule must come first
+	noRouteRule := &v1.RouteRule{
+		Name:      "noroute",
+		Condition: fmt.Sprintf("%s = true", NoRouteVariable),
+	}
+	proxyEndpoint.RouteRules = append([]*v1.RouteRule{noRouteRule}, proxyEndpoint.RouteRules...)
+
+	return policy.AssignMessage.Name, nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"testing"
+)
+
+func TestPathId(t *testing.T) {
+	proxyEndpoint := &v1.ProxyEndpoint{BasePath: "/v1"}
+	for _, path := range []string{"/pets/{id}", "/pets/{id}/", "/a-b", "/a/b", "/a.b"} {
+		proxyEndpoint.Flows = append(proxyEndpoint.Flows, &v1.ConditionalFlow{Verb: "GET", Path: path})
+	}
+
+	//generated flows do not change the identifiers
+	proxyEndpoint.Flows = append(proxyEndpoint.Flows, &v1.ConditionalFlow{Name: CatchAllFlowName})
+
+	tests := map[string]string{
+		"/pets/{id}":  "v1-pets-id",
+		"/pets/{id}/": "v1-pets-id--2",
+		"/a-b":        "v1-a-b",
+		"/a/b":        "v1-a-b--2",
+		"/a.b":        "v1-a.b",
+		"":            "v1",
+	}
+
+	for path, expected := range tests {
+		if actual := PathId(proxyEndpoint, path); actual != expected {
+			t.Errorf("expected the id of '%s' to be '%s', got '%s'", path, expected, actual)
+		}
+	}
+
+	if actual := PathId(&v1.ProxyEndpoint{BasePath: "/"}, ""); actual != "root" {
+		t.Errorf("expected the id of the root path to be 'root', got '%s'", actual)
+	}
+}
+
+func TestAddPolicy(t *testing.T) {
+	apiProxy := &v1.APIProxy{}
+
+	if _, err := AddPolicy(apiProxy, NewRaiseFaultPolicy("RF-Test", "404", "Not Found")); err != nil {
+		t.Fatal(err)
+	}
+
+	//the same policy is only added once
+	if _, err := AddPolicy(apiProxy, NewRaiseFaultPolicy("RF-Test", "404", "Not Found")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(apiProxy.Policies) != 1 {
+		t.Fatalf("expected a single policy, got %d", len(apiProxy.Policies))
+	}
+
+	if _, err := AddPolicy(apiProxy, NewRaiseFaultPolicy("RF-Test", "405", "Method Not Allowed")); err == nil {
+		t.Fatalf("expected an error for a different policy with the same name")
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"fmt"
+	"github.com/go-errors/errors"
+	"github.com/gosimple/slug"
+	v1 "github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"strings"
+)
+
+// APIProductExtension is an item of the top-level "x-Apigee-Products" extension, which describes the API products.
+// Within an operation, the "x-Apigee-Products" extension is the list of names of the API products that include it.
+type APIProductExtension struct {
+	Name         string   `yaml:"name"`
+	DisplayName  string   `yaml:"displayName"`
+	Description  string   `yaml:"description"`
+	ApprovalType string   `yaml:"approvalType"`
+	Environments []string `yaml:"environments"`
+}
+
+// AddAPIProducts generates the API products for the operations of the spec.
+// Operations go in the API products listed in their "x-Apigee-Products" extension. Otherwise, there is one
+// API product for each tag, and operations without tags go in an API product named after the API Proxy.
+// The scopes of each API product are the OAuth scopes required by its operations.
+// Nothing is generated unless the APIProducts option is set, or the spec has the top-level "x-Apigee-Products" extension.
+// It must run after the plugins have processed the API Proxy model, so that the API products only have the operations that remain.
+func AddAPIProducts(apiProxy *v1.APIProxy, options *Options) error {
+	var err error
+	var definitions []*APIProductExtension
+	extension, found := apiProxy.Extensions["x-Apigee-Products"]
+	if found && extension.Value != nil {
+		if err = extension.Value.Decode(&definitions); err != nil {
+			return errors.New(err)
+		}
+	}
+
+	if !options.APIProducts && !found {
+		return nil
+	}
+
+	productsByName := map[string]*v1.APIProduct{}
+	getProduct := func(name string, displayName string) *v1.APIProduct {
+		if product, ok := productsByName[name]; ok {
+			return product
+		}
+
+		if displayName == "" {
+			displayName = name
+		}
+
+		product := v1.NewAPIProduct(name, displayName)
+		for _, definition := range definitions {
+			if definition.Name != name {
+				continue
+			}
+			if definition.DisplayName != "" {
+				product.DisplayName = definition.DisplayName
+			}
+			if definition.ApprovalType != "" {
+				product.ApprovalType = definition.ApprovalType
+			}
+			product.Description = definition.Description
+			product.Environments = definition.Environments
+		}
+
+		productsByName[name] = product
+		apiProxy.APIProducts = append(apiProxy.APIProducts, product)
+		return product
+	}
+
+	for _, proxyEndpoint := range apiProxy.ProxyEndpoints {
+		for _, flow := range operationFlows(proxyEndpoint.Flows) {
+			var products []*v1.APIProduct
+			var names []string
+			if names, err = flowProductNames(flow); err != nil {
+				return err
+			}
+
+			if len(names) > 0 {
+				for _, name := range names {
+					products = append(products, getProduct(name, name))
+				}
+			} else if len(flow.Tags) > 0 {
+				for _, tag := range flow.Tags {
+					products = append(products, getProduct(fmt.Sprintf("%s-%s", apiProxy.Name, slug.Make(tag)), tag))
+				}
+			} else {
+				products = append(products, getProduct(apiProxy.Name, apiProxy.DisplayName))
+			}
+
+			requirements := flow.SecurityRequirement
+			if requirements == nil {
+				requirements = proxyEndpoint.SecurityRequirement
+			}
+
+			resource := ProductResource(flow.Path)
+			for _, product := range products {
+				product.AddOperation(apiProxy.Name, resource, flow.Verb)
+				if options.HeadToGet && flow.Verb == "GET" {
+					product.AddOperation(apiProxy.Name, resource, "HEAD")
+				}
+
+				for _, requirement := range requirements {
+					if requirement.Requirements == nil {
+						continue
+					}
+					for elem := requirement.Requirements.First(); elem != nil; elem = elem.Next() {
+						if scheme, ok := apiProxy.SecuritySchemes[elem.Key()]; ok && scheme.Type == "oauth2" {
+							for _, scope := range elem.Value() {
+								product.Scopes = AppendUnique(product.Scopes, scope)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// ProductResource converts an OAS path into an API product operation resource, where each templated segment becomes a wildcard
+func ProductResource(oasPath string) string {
+	segments := strings.Split(strings.Trim(oasPath, "/"), "/")
+	for index, segment := range segments {
+		if HasPathParams(segment) {
+			segments[index] = "*"
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// flowProductNames returns the value of the "x-Apigee-Products" extension within an operation
+func flowProductNames(flow *v1.ConditionalFlow) ([]string, error) {
+	extension, found := flow.Extensions["x-Apigee-Products"]
+	if !found || extension.Value == nil {
+		return nil, nil
+	}
+
+	var names []string
+	if err := extension.Value.Decode(&names); err != nil {
+		return nil, errors.Errorf("x-Apigee-Products extension in operation '%s' must be a list of API product names", FlowId(flow))
+	}
+	return names, nil
+}
@@ -0,0 +1,257 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"fmt"
+	"github.com/go-errors/errors"
+	v1 "github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"github.com/pb33f/libopenapi/datamodel/high/base"
+	"slices"
+	"strings"
+)
+
+// QuotaTimeUnits are the time units supported by the Quota policy
+var QuotaTimeUnits = []string{"minute", "hour", "day", "week", "month"}
+
+// QuotaExtension is the value of the "x-quota" extension, either at the top level of the spec, or within an operation
+type QuotaExtension struct {
+	Allow         int    `yaml:"allow"`
+	Interval      int    `yaml:"interval"`
+	TimeUnit      string `yaml:"timeUnit"`
+	Identifier    string `yaml:"identifier"`
+	UseAPIProduct bool   `yaml:"useAPIProduct"`
+}
+
+type QuotaAllow struct {
+	Count int `yaml:".count"`
+}
+
+type QuotaDefaultConfig struct {
+	Allow    int    `yaml:"Allow"`
+	Interval int    `yaml:"Interval"`
+	TimeUnit string `yaml:"TimeUnit"`
+}
+
+type QuotaPolicy struct {
+	Quota struct {
+		Name        string      `yaml:".name"`
+		DisplayName string      `yaml:"DisplayName"`
+		Allow       *QuotaAllow `yaml:"Allow,omitempty"`
+		Interval    int         `yaml:"Interval,omitempty"`
+		TimeUnit    string      `yaml:"TimeUnit,omitempty"`
+		Identifier  *struct {
+			Ref string `yaml:".ref"`
+		} `yaml:"Identifier,omitempty"`
+		Distributed                string `yaml:"Distributed"`
+		Synchronous                string `yaml:"Synchronous"`
+		UseQuotaConfigInAPIProduct *struct {
+			StepName      string              `yaml:".stepName"`
+			DefaultConfig *QuotaDefaultConfig `yaml:"DefaultConfig,omitempty"`
+		} `yaml:"UseQuotaConfigInAPIProduct,omitempty"`
+	} `yaml:"Quota"`
+}
+
+// NewQuotaPolicy creates a Quota policy with the values from the extension. If stepName is not empty, the
+// values come from the API product at runtime, and the values from the extension are only used as defaults.
+func NewQuotaPolicy(name string, config *QuotaExtension, stepName string) *QuotaPolicy {
+	policy := &QuotaPolicy{}
+	policy.Quota.Name = name
+	policy.Quota.DisplayName = name
+	policy.Quota.Distributed = "true"
+	policy.Quota.Synchronous = "true"
+
+	if config.Identifier != "" {
+		policy.Quota.Identifier = &struct {
+			Ref string `yaml:".ref"`
+		}{Ref: config.Identifier}
+	}
+
+	if stepName == "" {
+		policy.Quota.Allow = &QuotaAllow{Count: config.Allow}
+		policy.Quota.Interval = config.Interval
+		policy.Quota.TimeUnit = config.TimeUnit
+		return policy
+	}
+
+	policy.Quota.UseQuotaConfigInAPIProduct = &struct {
+		StepName      string              `yaml:".stepName"`
+		DefaultConfig *QuotaDefaultConfig `yaml:"DefaultConfig,omitempty"`
+	}{StepName: stepName}
+
+	if config.Allow > 0 {
+		policy.Quota.UseQuotaConfigInAPIProduct.DefaultConfig = &QuotaDefaultConfig{
+			Allow:    config.Allow,
+			Interval: config.Interval,
+			TimeUnit: config.TimeUnit,
+		}
+	}
+	return policy
+}
+
+// AddQuotas adds Quota policies from the "x-quota" extension. The operation-level extension takes precedence over the top-level one.
+//
+// The Quota steps go at the end of each operation's flow, so that they run after the security steps, which are also
+// within the flow (see AddSecurity). Operations that use the top-level extension share the same counter.
+//
+// With "useAPIProduct", the quota settings come from the API product of the app at runtime. This needs the name of the
+// VerifyAPIKey or OAuthV2 step that identified the app, so there is one Quota step for each security requirement
+// alternative, and every alternative must contain an apiKey or oauth2 scheme (e.g. an http bearer token alone does not
+// identify the app). Operations without security requirements have no app to count against, so they are rejected when
+// the extension is within the operation, and are not counted when it is at the top level.
+func AddQuotas(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, options *Options) error {
+	var err error
+	var globalConfig *QuotaExtension
+	if globalConfig, err = quotaConfig(apiProxy.Extensions); err != nil {
+		return err
+	}
+
+	for _, flow := range operationFlows(proxyEndpoint.Flows) {
+		var config *QuotaExtension
+		if config, err = quotaConfig(flow.Extensions); err != nil {
+			return err
+		}
+
+		name := fmt.Sprintf("Q-%s", FlowId(flow))
+		if config == nil {
+			config = globalConfig
+			name = fmt.Sprintf("Q-%s", PathId(proxyEndpoint, ""))
+		}
+
+		if config == nil {
+			continue
+		}
+
+		if !config.UseAPIProduct {
+			policy := NewQuotaPolicy(name, config, "")
+			if _, err = AddPolicy(apiProxy, policy); err != nil {
+				return err
+			}
+			flow.Request = append(flow.Request, v1.NewStep(policy.Quota.Name, "true"))
+			continue
+		}
+
+		requirements := flow.SecurityRequirement
+		if requirements == nil {
+			requirements = proxyEndpoint.SecurityRequirement
+		}
+
+		if !options.Security {
+			return errors.Errorf("x-quota with useAPIProduct in operation '%s' requires the security policies to identify the app", FlowId(flow))
+		}
+
+		var steps []*v1.Step
+		if steps, err = productQuotaSteps(apiProxy, flow, name, config, requirements); err != nil {
+			return err
+		}
+
+		if len(steps) == 0 && config != globalConfig {
+			return errors.Errorf("x-quota with useAPIProduct in operation '%s' requires an apiKey or oauth2 security requirement", FlowId(flow))
+		}
+
+		flow.Request = append(flow.Request, steps...)
+	}
+
+	return nil
+}
+
+// productQuotaSteps returns the steps for the Quota policies that use the API product settings, one for
+// each verification step that may have identified the app. There are no steps when security is optional.
+func productQuotaSteps(apiProxy *v1.APIProxy, flow *v1.ConditionalFlow, name string, config *QuotaExtension, requirements []*base.SecurityRequirement) ([]*v1.Step, error) {
+	for _, requirement := range requirements {
+		if requirement.ContainsEmptyRequirement || requirement.Requirements == nil || requirement.Requirements.Len() == 0 {
+			return nil, nil
+		}
+	}
+
+	var err error
+	var stepNames []string
+	var conditions = map[string][]string{}
+	for index, requirement := range requirements {
+		passed := fmt.Sprintf("(%s = \"%d\")", SecurityPassedVariable, index+1)
+		identified := false
+		for elem := requirement.Requirements.First(); elem != nil; elem = elem.Next() {
+			scheme, found := apiProxy.SecuritySchemes[elem.Key()]
+			if !found {
+				return nil, errors.Errorf("security scheme '%s' is not defined", elem.Key())
+			}
+
+			var stepName string
+			switch scheme.Type {
+			case "apiKey":
+				stepName = apiKeyPolicyName(scheme)
+			case "oauth2":
+				stepName = oauth2PolicyName(scheme)
+			default:
+				continue
+			}
+
+			if !slices.Contains(stepNames, stepName) {
+				stepNames = append(stepNames, stepName)
+			}
+			conditions[stepName] = AppendUnique(conditions[stepName], passed)
+			identified = true
+			//only one step per alternative can identify the app
+			break
+		}
+
+		if !identified {
+			//the requests that pass this alternative would not be counted
+			return nil, errors.Errorf("x-quota with useAPIProduct in operation '%s' requires an apiKey or oauth2 scheme in every security requirement alternative", FlowId(flow))
+		}
+	}
+
+	var steps []*v1.Step
+	for _, stepName := range stepNames {
+		policy := NewQuotaPolicy(fmt.Sprintf("%s-%s", name, stepName), config, stepName)
+		if _, err = AddPolicy(apiProxy, policy); err != nil {
+			return nil, err
+		}
+		steps = append(steps, v1.NewStep(policy.Quota.Name, strings.Join(conditions[stepName], " or ")))
+	}
+
+	return steps, nil
+}
+
+// quotaConfig returns the value of the "x-quota" extension, or nil if it is not present
+func quotaConfig(extensions map[string]*v1.Extension) (*QuotaExtension, error) {
+	extension, found := extensions["x-quota"]
+	if !found || extension.Value == nil {
+		return nil, nil
+	}
+
+	config := &QuotaExtension{}
+	if err := extension.Value.Decode(config); err != nil {
+		return nil, errors.New(err)
+	}
+
+	if config.Allow <= 0 && !config.UseAPIProduct {
+		return nil, errors.Errorf("x-quota requires a positive 'allow' value, unless 'useAPIProduct' is set")
+	}
+
+	if config.Interval == 0 {
+		config.Interval = 1
+	}
+
+	if config.TimeUnit == "" {
+		config.TimeUnit = "minute"
+	}
+
+	if !slices.Contains(QuotaTimeUnits, config.TimeUnit) {
+		return nil, errors.Errorf("x-quota timeUnit '%s' is not supported, it must be one of %v", config.TimeUnit, QuotaTimeUnits)
+	}
+
+	return config, nil
+}
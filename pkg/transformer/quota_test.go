@@ -0,0 +1,123 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"github.com/pb33f/libopenapi/datamodel/high/base"
+	"gopkg.in/yaml.v3"
+	"slices"
+	"testing"
+)
+
+func quotaExtension(value string) map[string]*v1.Extension {
+	node := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte(value), node); err != nil {
+		panic(err)
+	}
+	return map[string]*v1.Extension{"x-quota": {Name: "x-quota", Value: node.Content[0]}}
+}
+
+func TestAddQuotasWithAPIProduct(t *testing.T) {
+	tests := []struct {
+		name         string
+		global       bool
+		security     bool
+		requirements []*base.SecurityRequirement
+		steps        []string
+		err          bool
+	}{
+		{
+			name:         "apiKey OR oauth",
+			security:     true,
+			requirements: []*base.SecurityRequirement{requirement("apiKey"), requirement("oauth")},
+			steps:        []string{"Q-listPets-VA-apiKey", "Q-listPets-OA-VerifyAccessToken-oauth"},
+		},
+		{
+			name:         "mutual TLS AND apiKey",
+			security:     true,
+			requirements: []*base.SecurityRequirement{requirement("mtls", "apiKey")},
+			steps:        []string{"Q-listPets-VA-apiKey"},
+		},
+		{
+			name:         "bearer token",
+			security:     true,
+			requirements: []*base.SecurityRequirement{requirement("bearer")},
+			err:          true,
+		},
+		{
+			name:         "apiKey OR bearer token",
+			global:       true,
+			security:     true,
+			requirements: []*base.SecurityRequirement{requirement("apiKey"), requirement("bearer")},
+			err:          true,
+		},
+		{
+			name:     "operation without security",
+			security: true,
+			err:      true,
+		},
+		{
+			name:     "top-level extension, operation without security",
+			global:   true,
+			security: true,
+		},
+		{
+			name:         "security disabled",
+			global:       true,
+			requirements: []*base.SecurityRequirement{requirement("apiKey")},
+			err:          true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apiProxy := securityTestProxy()
+			flow := &v1.ConditionalFlow{Name: "listPets", Verb: "GET", Extensions: map[string]*v1.Extension{}}
+			proxyEndpoint := &v1.ProxyEndpoint{BasePath: "/v1", Flows: []*v1.ConditionalFlow{flow}}
+
+			extension := quotaExtension("useAPIProduct: true")
+			if test.global {
+				apiProxy.Extensions = extension
+				proxyEndpoint.SecurityRequirement = test.requirements
+			} else {
+				flow.Extensions = extension
+				flow.SecurityRequirement = test.requirements
+			}
+
+			options := NewOptions()
+			options.Security = test.security
+			err := AddQuotas(apiProxy, proxyEndpoint, options)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var steps []string
+			for _, step := range flow.Request {
+				steps = append(steps, step.Step.Name)
+			}
+
+			if !slices.Equal(steps, test.steps) {
+				t.Fatalf("expected steps %v, got %v", test.steps, steps)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"fmt"
+	"github.com/go-errors/errors"
+	v1 "github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"regexp"
+)
+
+// RateLimitExtension is the value of the "x-ratelimit" extension, either at the top level of the spec, or within an operation
+type RateLimitExtension struct {
+	Rate              string `yaml:"rate"`
+	Identifier        string `yaml:"identifier"`
+	UseEffectiveCount *bool  `yaml:"useEffectiveCount"`
+}
+
+type SpikeArrestPolicy struct {
+	SpikeArrest struct {
+		Name        string `yaml:".name"`
+		DisplayName string `yaml:"DisplayName"`
+		Identifier  *struct {
+			Ref string `yaml:".ref"`
+		} `yaml:"Identifier,omitempty"`
+		Rate              string `yaml:"Rate"`
+		UseEffectiveCount bool   `yaml:"UseEffectiveCount"`
+	} `yaml:"SpikeArrest"`
+}
+
+var rateRegex = regexp.MustCompile(`^[1-9][0-9]*(ps|pm)$`)
+
+func NewSpikeArrestPolicy(name string, config *RateLimitExtension) *SpikeArrestPolicy {
+	policy := &SpikeArrestPolicy{}
+	policy.SpikeArrest.Name = name
+	policy.SpikeArrest.DisplayName = name
+	policy.SpikeArrest.Rate = config.Rate
+	policy.SpikeArrest.UseEffectiveCount = config.UseEffectiveCount == nil || *config.UseEffectiveCount
+	if config.Identifier != "" {
+		policy.SpikeArrest.Identifier = &struct {
+			Ref string `yaml:".ref"`
+		}{Ref: config.Identifier}
+	}
+	return policy
+}
+
+// AddRateLimits adds SpikeArrest policies from the "x-ratelimit" extension.
+// The top-level extension adds a policy to the flows of the operations that do not have their own extension, and to all the other
+// flows (including the catch-all flow for the requests that do not match any operation).
+// The operation-level extension adds a policy to the operation's flow. Each operation gets its own policy (and counter).
+// The policies come after the security steps, so that the identifier can refer to variables set by them (e.g. client_id).
+func AddRateLimits(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, options *Options) error {
+	var err error
+	exempt := map[*v1.ConditionalFlow]bool{}
+	for _, flow := range operationFlows(proxyEndpoint.Flows) {
+		var config *RateLimitExtension
+		if config, err = rateLimitConfig(flow.Extensions); err != nil {
+			return err
+		}
+		if config == nil {
+			continue
+		}
+
+		policy := NewSpikeArrestPolicy(fmt.Sprintf("SA-%s", FlowId(flow)), config)
+		if _, err = AddPolicy(apiProxy, policy); err != nil {
+			return err
+		}
+
+		flow.Request = append(flow.Request, v1.NewStep(policy.SpikeArrest.Name, "true"))
+		exempt[flow] = true
+	}
+
+	var config *RateLimitExtension
+	if config, err = rateLimitConfig(apiProxy.Extensions); err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+
+	policy := NewSpikeArrestPolicy(fmt.Sprintf("SA-%s", PathId(proxyEndpoint, "")), config)
+	if _, err = AddPolicy(apiProxy, policy); err != nil {
+		return err
+	}
+
+	hasOwnLimit := func(flow *v1.ConditionalFlow) bool {
+		return exempt[flow]
+	}
+
+	for _, flow := range DefaultFlows(proxyEndpoint, hasOwnLimit) {
+		flow.Request = append(flow.Request, v1.NewStep(policy.SpikeArrest.Name, "true"))
+	}
+	return nil
+}
+
+// rateLimitConfig returns the value of the "x-ratelimit" extension, or nil if it is not present
+func rateLimitConfig(extensions map[string]*v1.Extension) (*RateLimitExtension, error) {
+	extension, found := extensions["x-ratelimit"]
+	if !found || extension.Value == nil {
+		return nil, nil
+	}
+
+	config := &RateLimitExtension{}
+	if err := extension.Value.Decode(config); err != nil {
+		return nil, errors.New(err)
+	}
+
+	if !rateRegex.MatchString(config.Rate) {
+		return nil, errors.Errorf("x-ratelimit rate '%s' is not valid, it must be a number followed by ps or pm (e.g. 30ps)", config.Rate)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,233 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"fmt"
+	"github.com/go-errors/errors"
+	v1 "github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"slices"
+	"strings"
+)
+
+// InjectionPatterns are the baseline patterns for detecting injection attacks in the request path and parameters
+var InjectionPatterns = []string{
+	//SQL injection
+	`(?i)\b(union(\s+all)?\s+select|select\s+.+\s+from|insert\s+into|delete\s+from|drop\s+table|update\s+\S+\s+set|exec(ute)?\s*\()\b`,
+	`(?i)'\s*(or|and)\s+'?[^']*'?\s*=`,
+	//server-side include injection
+	`<!--#(include|exec|echo|config|printenv)\s+.*`,
+	//JavaScript injection
+	`<\s*script\b[^>]*>[^<]+<\s*/\s*script\s*>`,
+}
+
+type RegexPattern struct {
+	Pattern string `yaml:"Pattern"`
+}
+
+type RegexTarget struct {
+	Name     string          `yaml:".name,omitempty"`
+	Patterns []*RegexPattern `yaml:".@"`
+}
+
+func NewRegexTarget(name string, patterns []string) *RegexTarget {
+	target := &RegexTarget{Name: name}
+	for _, pattern := range patterns {
+		target.Patterns = append(target.Patterns, &RegexPattern{Pattern: escapeXML(pattern)})
+	}
+	return target
+}
+
+type RegexProtectionItem struct {
+	URIPath    *RegexTarget `yaml:"URIPath,omitempty"`
+	QueryParam *RegexTarget `yaml:"QueryParam,omitempty"`
+	Header     *RegexTarget `yaml:"Header,omitempty"`
+}
+
+type RegularExpressionProtectionPolicy struct {
+	RegularExpressionProtection struct {
+		Name                      string                 `yaml:".name"`
+		DisplayName               string                 `yaml:"DisplayName"`
+		Source                    string                 `yaml:"Source"`
+		IgnoreUnresolvedVariables string                 `yaml:"IgnoreUnresolvedVariables"`
+		Items                     []*RegexProtectionItem `yaml:".@"`
+	} `yaml:"RegularExpressionProtection"`
+}
+
+// AddParameter adds the patterns for a query or header parameter
+func (p *RegularExpressionProtectionPolicy) AddParameter(param *v1.Parameter, patterns []string) {
+	switch param.In {
+	case "query":
+		p.RegularExpressionProtection.Items = append(p.RegularExpressionProtection.Items,
+			&RegexProtectionItem{QueryParam: NewRegexTarget(param.Name, patterns)})
+	case "header":
+		p.RegularExpressionProtection.Items = append(p.RegularExpressionProtection.Items,
+			&RegexProtectionItem{Header: NewRegexTarget(param.Name, patterns)})
+	}
+}
+
+func NewRegularExpressionProtectionPolicy(name string) *RegularExpressionProtectionPolicy {
+	policy := &RegularExpressionProtectionPolicy{}
+	policy.RegularExpressionProtection.Name = name
+	policy.RegularExpressionProtection.DisplayName = name
+	policy.RegularExpressionProtection.Source = "request"
+	policy.RegularExpressionProtection.IgnoreUnresolvedVariables = "true"
+	return policy
+}
+
+// AddRegexProtection adds RegularExpressionProtection policies that reject (with HTTP 400) query and header parameters
+// that do not match the pattern or enum declared in the spec. Path parameters are checked by the flow conditions (see
+// AddPathParameterValidation).
+// Optionally, it also adds a policy that rejects requests containing common injection patterns. This is turned on with the
+// InjectionProtection option, or with the "x-Apigee-InjectionProtection" extension (at the top level, or within an operation).
+func AddRegexProtection(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, options *Options) error {
+	var err error
+	for _, flow := range operationFlows(proxyEndpoint.Flows) {
+		var steps []*v1.Step
+
+		if options.ParameterValidation {
+			policy := NewRegularExpressionProtectionPolicy(fmt.Sprintf("RE-Params-%s", FlowId(flow)))
+			for _, param := range flow.Parameters {
+				if regexes := invalidValueRegexes(param); len(regexes) > 0 {
+					policy.AddParameter(param, regexes)
+				}
+			}
+
+			if len(policy.RegularExpressionProtection.Items) > 0 {
+				if _, err = AddPolicy(apiProxy, policy); err != nil {
+					return err
+				}
+				steps = append(steps, v1.NewStep(policy.RegularExpressionProtection.Name, "true"))
+			}
+		}
+
+		var injectionProtection bool
+		if injectionProtection, err = injectionProtectionEnabled(apiProxy, flow, options); err != nil {
+			return err
+		}
+
+		if injectionProtection {
+			policy := NewRegularExpressionProtectionPolicy(fmt.Sprintf("RE-Injection-%s", FlowId(flow)))
+			policy.RegularExpressionProtection.Items = []*RegexProtectionItem{{URIPath: NewRegexTarget("", InjectionPatterns)}}
+			for _, param := range flow.Parameters {
+				policy.AddParameter(param, InjectionPatterns)
+			}
+
+			if _, err = AddPolicy(apiProxy, policy); err != nil {
+				return err
+			}
+			steps = append(steps, v1.NewStep(policy.RegularExpressionProtection.Name, "true"))
+		}
+
+		flow.Request = append(steps, flow.Request...)
+	}
+
+	return nil
+}
+
+// AddPathParameterValidation adds a flow for each path with constrained path parameters (see BuildPathCondition), that rejects
+// (with HTTP 400) the requests for the path with parameter values that do not match. Otherwise, these requests would not match
+// any of the operation flows, and would go to the backend. The flows loosely match the path template, so they must come after the
+// operation flows (and the flows for OPTIONS and HTTP 405, which match the same requests with valid parameter values).
+func AddPathParameterValidation(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, options *Options) error {
+	if !options.ParameterValidation {
+		return nil
+	}
+
+	var err error
+	var newFlows []*v1.ConditionalFlow
+	paths, flowsByPath := GroupFlowsByPath(operationFlows(proxyEndpoint.Flows))
+	for _, path := range paths {
+		parameters := flowsByPath[path][0].Parameters
+		if !HasPathParams(path) || !needsRegex(path, parameters) {
+			continue
+		}
+
+		looseCondition := BuildPathCondition(path, looseParameters(parameters), options)
+		if looseCondition == BuildPathCondition(path, parameters, options) {
+			continue
+		}
+
+		policy := NewRaiseFaultPolicy(fmt.Sprintf("RF-HTTP400-PathParams-%s", PathId(proxyEndpoint, path)), "400", "Bad Request")
+		if _, err = AddPolicy(apiProxy, policy); err != nil {
+			return err
+		}
+
+		newFlows = append(newFlows, &v1.ConditionalFlow{
+			Name:        fmt.Sprintf("invalid-path-params-%s", PathId(proxyEndpoint, path)),
+			Description: fmt.Sprintf("Responds HTTP 400 for invalid path parameters on %s", DisplayPath(proxyEndpoint, path)),
+			Condition:   looseCondition,
+			Request:     []*v1.Step{v1.NewStep(policy.RaiseFault.Name, "true")},
+			Response:    []*v1.Step{},
+			Extensions:  map[string]*v1.Extension{},
+			Path:        path,
+		})
+	}
+
+	proxyEndpoint.Flows = append(proxyEndpoint.Flows, newFlows...)
+	return nil
+}
+
+// looseParameters returns the path parameters without their schemas, so that they match any value
+func looseParameters(parameters []*v1.Parameter) []*v1.Parameter {
+	var result []*v1.Parameter
+	for _, param := range parameters {
+		if param.In == "path" {
+			result = append(result, &v1.Parameter{Name: param.Name, In: param.In, AllowReserved: param.AllowReserved})
+		}
+	}
+	return result
+}
+
+// invalidValueRegexes returns the regexes that match the values that are not valid for the parameter, one
+// for the pattern and one for the enum declared in the spec.
+// The spec patterns are not implicitly anchored, so a value is valid if the pattern matches any part of it.
+func invalidValueRegexes(param *v1.Parameter) []string {
+	schema := param.Schema
+	if schema == nil || slices.Contains(schema.Type, "array") || slices.Contains(schema.Type, "object") {
+		return nil
+	}
+
+	var validRegexes []string
+	if schema.Pattern != "" {
+		validRegexes = append(validRegexes, schema.Pattern)
+	}
+
+	if len(schema.Enum) > 0 {
+		var values []string
+		for _, value := range schema.Enum {
+			values = append(values, escapeRegexLiteral(value.Value))
+		}
+		validRegexes = append(validRegexes, fmt.Sprintf("^(%s)$", strings.Join(values, "|")))
+	}
+
+	var result []string
+	for _, validRegex := range validRegexes {
+		result = append(result, fmt.Sprintf("(?s)^(?!.*?(?:%s)).*", validRegex))
+	}
+	return result
+}
+
+func injectionProtectionEnabled(apiProxy *v1.APIProxy, flow *v1.ConditionalFlow, options *Options) (bool, error) {
+	enabled := options.InjectionProtection
+	for _, extensions := range []map[string]*v1.Extension{apiProxy.Extensions, flow.Extensions} {
+		if extension, found := extensions["x-Apigee-InjectionProtection"]; found && extension.Value != nil {
+			if err := extension.Value.Decode(&enabled); err != nil {
+				return false, errors.New(err)
+			}
+		}
+	}
+	return enabled, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"github.com/pb33f/libopenapi/datamodel/high/base"
+	"testing"
+)
+
+func TestAddPathParameterValidation(t *testing.T) {
+	integer := &base.Schema{Type: []string{"integer"}}
+	str := &base.Schema{Type: []string{"string"}}
+
+	tests := []struct {
+		name       string
+		path       string
+		parameters []*v1.Parameter
+		condition  string
+		invalid    []string
+	}{
+		{
+			name:       "typed parameter",
+			path:       "/pets/{id}",
+			parameters: []*v1.Parameter{pathParameter("id", integer)},
+			condition:  `proxy.pathsuffix MatchesPath "/pets/*"`,
+		},
+		{
+			name:       "typed suffixed parameter",
+			path:       "/files/{id}.json",
+			parameters: []*v1.Parameter{pathParameter("id", integer)},
+			invalid:    []string{"/files/abc.json"},
+		},
+		{
+			name:       "unconstrained parameter",
+			path:       "/pets/{name}",
+			parameters: []*v1.Parameter{pathParameter("name", str)},
+		},
+		{
+			name:       "unconstrained suffixed parameter",
+			path:       "/files/{name}.json",
+			parameters: []*v1.Parameter{pathParameter("name", str)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apiProxy := &v1.APIProxy{}
+			operationFlow := &v1.ConditionalFlow{Name: "op", Verb: "GET", Path: test.path, Parameters: test.parameters}
+			proxyEndpoint := &v1.ProxyEndpoint{BasePath: "/v1", Flows: []*v1.ConditionalFlow{operationFlow}}
+
+			options := NewOptions()
+			options.ParameterValidation = true
+			if err := AddPathParameterValidation(apiProxy, proxyEndpoint, options); err != nil {
+				t.Fatal(err)
+			}
+
+			expectFlow := test.condition != "" || len(test.invalid) > 0
+			if !expectFlow {
+				if len(proxyEndpoint.Flows) != 1 || len(apiProxy.Policies) != 0 {
+					t.Fatalf("expected no validation flow, got %d flows", len(proxyEndpoint.Flows))
+				}
+				return
+			}
+
+			if len(proxyEndpoint.Flows) != 2 || proxyEndpoint.Flows[0] != operationFlow {
+				t.Fatalf("expected the validation flow after the operation flow, got %d flows", len(proxyEndpoint.Flows))
+			}
+
+			flow := proxyEndpoint.Flows[1]
+			if flow.Verb != "" || !RespondsWithinProxy(flow) {
+				t.Fatalf("expected the validation flow not to be an operation")
+			}
+
+			if test.condition != "" && flow.Condition != test.condition {
+				t.Fatalf("expected the condition '%s', got '%s'", test.condition, flow.Condition)
+			}
+
+			for _, path := range test.invalid {
+				if !conditionRegex(t, flow.Condition).MatchString(path) {
+					t.Errorf("expected the validation flow to match '%s'", path)
+				}
+				if conditionRegex(t, BuildPathCondition(test.path, test.parameters, options)).MatchString(path) {
+					t.Errorf("expected the operation flow not to match '%s'", path)
+				}
+			}
+		})
+	}
+}
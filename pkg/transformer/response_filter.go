@@ -0,0 +1,302 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/go-errors/errors"
+	v1 "github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"github.com/micovery/spec2proxy/pkg/templates"
+	"github.com/pb33f/libopenapi/datamodel/high/base"
+	"slices"
+	"strings"
+)
+
+// ResponseFilterResourceFile is the name of the JavaScript resource that prunes the response bodies
+const ResponseFilterResourceFile = "response-filter.js"
+
+// ResponseFilter describes which properties of a JSON value are kept by the response filter script.
+// A nil filter keeps the value as-is. A filter with a Ref is replaced by the named definition (for recursive schemas).
+type ResponseFilter struct {
+	Ref                  string                     `json:"$ref,omitempty"`
+	Type                 string                     `json:"type,omitempty"`
+	Properties           map[string]*ResponseFilter `json:"properties,omitempty"`
+	AdditionalProperties any                        `json:"additionalProperties,omitempty"`
+	Items                *ResponseFilter            `json:"items,omitempty"`
+}
+
+type JSProperty struct {
+	Property struct {
+		Name  string `yaml:".name"`
+		Value string `yaml:".@"`
+	} `yaml:"Property"`
+}
+
+type JavascriptPolicy struct {
+	Javascript struct {
+		Name        string `yaml:".name"`
+		TimeLimit   string `yaml:".timeLimit"`
+		DisplayName string `yaml:"DisplayName"`
+		Properties  struct {
+			Items []*JSProperty `yaml:".@"`
+		} `yaml:"Properties"`
+		ResourceURL string `yaml:"ResourceURL"`
+	} `yaml:"Javascript"`
+}
+
+// AddResponseFilter adds JavaScript policies that prune the JSON response bodies down to the properties declared
+// in the response schema of each operation (for the status code of the response).
+// Nested objects and arrays are pruned recursively, including recursive schemas. Objects with "additionalProperties" keep
+// their undeclared properties (filtered by the additionalProperties schema, if any). Objects without any declared properties
+// are kept as-is.
+// This is turned on with the "x-Apigee-ResponseFilter" extension (at the top level of the spec, or within an operation).
+func AddResponseFilter(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, options *Options) error {
+	var err error
+	for _, flow := range operationFlows(proxyEndpoint.Flows) {
+		var enabled bool
+		if enabled, err = responseFilterEnabled(apiProxy, flow); err != nil {
+			return err
+		}
+
+		if !enabled {
+			continue
+		}
+
+		builder := NewResponseFilterBuilder()
+		filters := map[string]*ResponseFilter{}
+		for _, response := range flow.Responses {
+			mediaType := mockMediaType(response.Content)
+			if mediaType == nil || mediaType.Schema == nil || !strings.Contains(mediaType.ContentType, "json") {
+				continue
+			}
+
+			if filter := builder.Build(mediaType.Schema); filter != nil {
+				filters[strings.ReplaceAll(response.StatusCode, "x", "X")] = filter
+			}
+		}
+
+		if len(filters) == 0 {
+			continue
+		}
+
+		if err = addResponseFilterResource(apiProxy); err != nil {
+			return err
+		}
+
+		var filtersProperty, definitionsProperty *JSProperty
+		if filtersProperty, err = jsonProperty("filters", filters); err != nil {
+			return err
+		}
+		if definitionsProperty, err = jsonProperty("definitions", builder.Definitions); err != nil {
+			return err
+		}
+
+		policy := JavascriptPolicy{}
+		policy.Javascript.Name = fmt.Sprintf("JS-ResponseFilter-%s", FlowId(flow))
+		policy.Javascript.DisplayName = policy.Javascript.Name
+		policy.Javascript.TimeLimit = "200"
+		policy.Javascript.Properties.Items = []*JSProperty{filtersProperty, definitionsProperty}
+		policy.Javascript.ResourceURL = fmt.Sprintf("jsc://%s", ResponseFilterResourceFile)
+
+		if _, err = AddPolicy(apiProxy, policy); err != nil {
+			return err
+		}
+
+		//the body is pruned before any other response step (e.g. the response cache stores the pruned body)
+		flow.Response = append([]*v1.Step{v1.NewStep(policy.Javascript.Name, "true")}, flow.Response...)
+	}
+
+	return nil
+}
+
+// jsonProperty returns a property of the JavaScript policy, with the value encoded as JSON
+func jsonProperty(name string, value any) (*JSProperty, error) {
+	var valueJSON bytes.Buffer
+	encoder := json.NewEncoder(&valueJSON)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, errors.New(err)
+	}
+
+	property := &JSProperty{}
+	property.Property.Name = name
+	property.Property.Value = escapeXML(strings.TrimSpace(valueJSON.String()))
+	return property, nil
+}
+
+// ResponseFilterBuilder builds the filters for the response schemas of an operation.
+// The filters of recursive schemas are kept in the Definitions (by reference), and referenced by name from the filters.
+type ResponseFilterBuilder struct {
+	Definitions map[string]*ResponseFilter
+	recursive   map[string]bool
+}
+
+func NewResponseFilterBuilder() *ResponseFilterBuilder {
+	return &ResponseFilterBuilder{
+		Definitions: map[string]*ResponseFilter{},
+		recursive:   map[string]bool{},
+	}
+}
+
+// Build returns the filter for the values described by the schema, or nil if they must be kept as-is
+func (b *ResponseFilterBuilder) Build(schema *base.Schema) *ResponseFilter {
+	return b.build(schema, nil)
+}
+
+func (b *ResponseFilterBuilder) build(schema *base.Schema, refs []string) *ResponseFilter {
+	if schema == nil {
+		return nil
+	}
+
+	var composed []*base.SchemaProxy
+	composed = append(composed, schema.AllOf...)
+	composed = append(composed, schema.OneOf...)
+	composed = append(composed, schema.AnyOf...)
+	if len(composed) > 0 {
+		//the properties of all the sub-schemas are kept, so that the value is valid for whichever sub-schema it matches
+		result := &ResponseFilter{Type: "object", Properties: map[string]*ResponseFilter{}}
+		if orderedPropertiesLen(schema) > 0 {
+			composed = append(composed, base.CreateSchemaProxy(&base.Schema{Properties: schema.Properties, AdditionalProperties: schema.AdditionalProperties}))
+		}
+
+		for _, subSchema := range composed {
+			filter := b.resolve(b.buildProxy(subSchema, refs))
+			if filter == nil || filter.Type != "object" {
+				return nil
+			}
+
+			for name, property := range filter.Properties {
+				result.Properties[name] = property
+			}
+
+			if filter.AdditionalProperties != nil && result.AdditionalProperties != true {
+				result.AdditionalProperties = filter.AdditionalProperties
+			}
+		}
+		return result
+	}
+
+	if slices.Contains(schema.Type, "array") || (schema.Items != nil && schema.Items.IsA()) {
+		result := &ResponseFilter{Type: "array"}
+		if schema.Items != nil && schema.Items.IsA() {
+			result.Items = b.buildProxy(schema.Items.A, refs)
+		}
+
+		if result.Items == nil {
+			return nil
+		}
+		return result
+	}
+
+	if orderedPropertiesLen(schema) == 0 && schema.AdditionalProperties == nil {
+		//free-form object, or a scalar value
+		return nil
+	}
+
+	result := &ResponseFilter{Type: "object", Properties: map[string]*ResponseFilter{}}
+	for elem := schema.Properties.First(); elem != nil; elem = elem.Next() {
+		result.Properties[elem.Key()] = b.buildProxy(elem.Value(), refs)
+	}
+
+	if schema.AdditionalProperties != nil {
+		if schema.AdditionalProperties.IsB() {
+			if schema.AdditionalProperties.B {
+				result.AdditionalProperties = true
+			}
+		} else if filter := b.buildProxy(schema.AdditionalProperties.A, refs); filter != nil {
+			result.AdditionalProperties = filter
+		} else {
+			result.AdditionalProperties = true
+		}
+	}
+
+	return result
+}
+
+// buildProxy returns the filter for a schema proxy. Recursive references point to the definition of the referenced schema,
+// which is added once the outermost reference has been built.
+func (b *ResponseFilterBuilder) buildProxy(proxy *base.SchemaProxy, refs []string) *ResponseFilter {
+	if proxy == nil {
+		return nil
+	}
+
+	if !proxy.IsReference() {
+		return b.build(proxy.Schema(), refs)
+	}
+
+	ref := proxy.GetReference()
+	if _, found := b.Definitions[ref]; found || slices.Contains(refs, ref) {
+		b.recursive[ref] = true
+		return &ResponseFilter{Ref: ref}
+	}
+
+	filter := b.build(proxy.Schema(), append(slices.Clone(refs), ref))
+	if !b.recursive[ref] || filter == nil {
+		return filter
+	}
+
+	b.Definitions[ref] = filter
+	return &ResponseFilter{Ref: ref}
+}
+
+// resolve returns the definition for a filter that references one
+func (b *ResponseFilterBuilder) resolve(filter *ResponseFilter) *ResponseFilter {
+	if filter != nil && filter.Ref != "" {
+		return b.Definitions[filter.Ref]
+	}
+	return filter
+}
+
+func orderedPropertiesLen(schema *base.Schema) int {
+	if schema.Properties == nil {
+		return 0
+	}
+	return schema.Properties.Len()
+}
+
+// addResponseFilterResource adds the response filter script as a resource of the API Proxy, if not already there
+func addResponseFilterResource(apiProxy *v1.APIProxy) error {
+	for _, resource := range apiProxy.Resources {
+		if resource.ResourceType == "jsc" && resource.ResourceFile == ResponseFilterResourceFile {
+			return nil
+		}
+	}
+
+	content, err := templates.FS.ReadFile(fmt.Sprintf("resources/%s", ResponseFilterResourceFile))
+	if err != nil {
+		return errors.New(err)
+	}
+
+	apiProxy.Resources = append(apiProxy.Resources, &v1.Resource{
+		ResourceType: "jsc",
+		ResourceFile: ResponseFilterResourceFile,
+		Content:      content,
+	})
+	return nil
+}
+
+func responseFilterEnabled(apiProxy *v1.APIProxy, flow *v1.ConditionalFlow) (bool, error) {
+	enabled := false
+	for _, extensions := range []map[string]*v1.Extension{apiProxy.Extensions, flow.Extensions} {
+		if extension, found := extensions["x-Apigee-ResponseFilter"]; found && extension.Value != nil {
+			if err := extension.Value.Decode(&enabled); err != nil {
+				return false, errors.New(err)
+			}
+		}
+	}
+	return enabled, nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"encoding/json"
+	"github.com/pb33f/libopenapi"
+	"testing"
+)
+
+const responseFilterSpec = `
+openapi: 3.0.3
+info:
+  title: filter
+  version: "1"
+paths: {}
+components:
+  schemas:
+    Pet:
+      type: object
+      properties:
+        id: {type: integer}
+        tags: {type: array, items: {type: string}}
+    Node:
+      type: object
+      properties:
+        name: {type: string}
+        children: {type: array, items: {$ref: '#/components/schemas/Node'}}
+    Tree:
+      type: object
+      properties:
+        root: {$ref: '#/components/schemas/Node'}
+    Dog:
+      allOf:
+        - $ref: '#/components/schemas/Pet'
+        - type: object
+          properties:
+            breed: {type: string}
+    Mixed:
+      oneOf:
+        - $ref: '#/components/schemas/Pet'
+        - type: string
+    Labels:
+      type: object
+      properties:
+        owner: {$ref: '#/components/schemas/Pet'}
+      additionalProperties:
+        $ref: '#/components/schemas/Pet'
+    Extensible:
+      type: object
+      properties:
+        id: {type: integer}
+      additionalProperties: true
+    Pets:
+      type: array
+      items: {$ref: '#/components/schemas/Pet'}
+    FreeForm:
+      type: object
+`
+
+func TestResponseFilterBuilder(t *testing.T) {
+	document, err := libopenapi.NewDocument([]byte(responseFilterSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, errs := document.BuildV3Model()
+	if len(errs) > 0 {
+		t.Fatal(errs[0])
+	}
+
+	const pet = `{"type":"object","properties":{"id":null,"tags":null}}`
+	const node = `{"type":"object","properties":{"children":{"type":"array","items":{"$ref":"#/components/schemas/Node"}},"name":null}}`
+
+	tests := []struct {
+		schema      string
+		filter      string
+		definitions string
+	}{
+		{schema: "Pet", filter: pet},
+		{schema: "Node", filter: node, definitions: `{"#/components/schemas/Node":` + node + `}`},
+		{schema: "Tree", filter: `{"type":"object","properties":{"root":{"$ref":"#/components/schemas/Node"}}}`, definitions: `{"#/components/schemas/Node":` + node + `}`},
+		{schema: "Dog", filter: `{"type":"object","properties":{"breed":null,"id":null,"tags":null}}`},
+		{schema: "Mixed", filter: `null`},
+		{schema: "Labels", filter: `{"type":"object","properties":{"owner":` + pet + `},"additionalProperties":` + pet + `}`},
+		{schema: "Extensible", filter: `{"type":"object","properties":{"id":null},"additionalProperties":true}`},
+		{schema: "Pets", filter: `{"type":"array","items":` + pet + `}`},
+		{schema: "FreeForm", filter: `null`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.schema, func(t *testing.T) {
+			proxy := model.Model.Components.Schemas.GetOrZero(test.schema)
+			if proxy == nil {
+				t.Fatalf("schema '%s' not found", test.schema)
+			}
+
+			builder := NewResponseFilterBuilder()
+			filter := builder.Build(proxy.Schema())
+
+			if actual := marshalFilter(t, filter); actual != test.filter {
+				t.Errorf("expected the filter %s, got %s", test.filter, actual)
+			}
+
+			if test.definitions == "" {
+				test.definitions = "{}"
+			}
+			if actual := marshalFilter(t, builder.Definitions); actual != test.definitions {
+				t.Errorf("expected the definitions %s, got %s", test.definitions, actual)
+			}
+		})
+	}
+}
+
+func marshalFilter(t *testing.T, value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
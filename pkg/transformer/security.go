@@ -0,0 +1,638 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"fmt"
+	"github.com/go-errors/errors"
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"github.com/pb33f/libopenapi/datamodel/high/base"
+	"strings"
+)
+
+type VerifyAPIKeyPolicy struct {
+	VerifyAPIKey struct {
+		Name            string `yaml:".name"`
+		ContinueOnError string `yaml:".continueOnError,omitempty"`
+		DisplayName     string `yaml:"DisplayName"`
+		APIKey          struct {
+			Ref string `yaml:".ref"`
+		} `yaml:"APIKey"`
+	} `yaml:"VerifyAPIKey"`
+}
+
+type OAuthV2Policy struct {
+	OAuthV2 struct {
+		Name            string `yaml:".name"`
+		ContinueOnError string `yaml:".continueOnError,omitempty"`
+		DisplayName     string `yaml:"DisplayName"`
+		Operation       string `yaml:"Operation"`
+	} `yaml:"OAuthV2"`
+}
+
+type JWKS struct {
+	URI string `yaml:".uri"`
+}
+
+type VerifyJWTPolicy struct {
+	VerifyJWT struct {
+		Name            string `yaml:".name"`
+		ContinueOnError string `yaml:".continueOnError,omitempty"`
+		DisplayName     string `yaml:"DisplayName"`
+		Algorithm       string `yaml:"Algorithm"`
+		Source          string `yaml:"Source,omitempty"`
+		PublicKey       struct {
+			JWKS JWKS `yaml:"JWKS"`
+		} `yaml:"PublicKey"`
+		Issuer   string `yaml:"Issuer,omitempty"`
+		Audience string `yaml:"Audience,omitempty"`
+	} `yaml:"VerifyJWT"`
+}
+
+// JWTExtension is the value of the "x-Apigee-JWT" extension within a security scheme
+type JWTExtension struct {
+	JWKSUri   string `yaml:"jwksUri"`
+	Issuer    string `yaml:"issuer"`
+	Audience  string `yaml:"audience"`
+	Algorithm string `yaml:"algorithm"`
+}
+
+type BasicAuthenticationPolicy struct {
+	BasicAuthentication struct {
+		Name                      string `yaml:".name"`
+		ContinueOnError           string `yaml:".continueOnError,omitempty"`
+		DisplayName               string `yaml:"DisplayName"`
+		Operation                 string `yaml:"Operation"`
+		IgnoreUnresolvedVariables string `yaml:"IgnoreUnresolvedVariables"`
+		User                      struct {
+			Ref string `yaml:".ref"`
+		} `yaml:"User"`
+		Password struct {
+			Ref string `yaml:".ref"`
+		} `yaml:"Password"`
+		Source   string `yaml:"Source,omitempty"`
+		AssignTo *struct {
+			CreateNew string `yaml:".createNew"`
+			Value     string `yaml:".@"`
+		} `yaml:"AssignTo,omitempty"`
+	} `yaml:"BasicAuthentication"`
+}
+
+type KVMParameter struct {
+	Parameter struct {
+		Ref   string `yaml:".ref,omitempty"`
+		Value string `yaml:".@,omitempty"`
+	} `yaml:"Parameter"`
+}
+
+// KVMRef returns a KVM key parameter that references a flow variable
+func KVMRef(ref string) *KVMParameter {
+	parameter := &KVMParameter{}
+	parameter.Parameter.Ref = ref
+	return parameter
+}
+
+// KVMValue returns a KVM key parameter with a literal value
+func KVMValue(value string) *KVMParameter {
+	parameter := &KVMParameter{}
+	parameter.Parameter.Value = value
+	return parameter
+}
+
+type KVMGet struct {
+	Get struct {
+		AssignTo string          `yaml:".assignTo"`
+		Key      []*KVMParameter `yaml:"Key"`
+	} `yaml:"Get"`
+}
+
+type KeyValueMapOperationsPolicy struct {
+	KeyValueMapOperations struct {
+		Name             string    `yaml:".name"`
+		ContinueOnError  string    `yaml:".continueOnError,omitempty"`
+		MapIdentifier    string    `yaml:".mapIdentifier"`
+		DisplayName      string    `yaml:"DisplayName"`
+		Scope            string    `yaml:"Scope"`
+		ExpiryTimeInSecs string    `yaml:"ExpiryTimeInSecs"`
+		Operations       []*KVMGet `yaml:".@"`
+	} `yaml:"KeyValueMapOperations"`
+}
+
+func NewKVMGet(assignTo string, key ...*KVMParameter) *KVMGet {
+	get := &KVMGet{}
+	get.Get.AssignTo = assignTo
+	get.Get.Key = key
+	return get
+}
+
+const (
+	// BasicAuthForward decodes the credentials into flow variables, and forwards the Authorization header to the backend
+	BasicAuthForward = "forward"
+
+	// BasicAuthKVM checks the credentials against entries in a KVM
+	BasicAuthKVM = "kvm"
+
+	// BasicAuthReencode checks the credentials against entries in a KVM, and replaces them with backend credentials
+	BasicAuthReencode = "reencode"
+)
+
+// BasicAuthExtension is the value of the "x-Apigee-BasicAuth" extension within a security scheme
+type BasicAuthExtension struct {
+	Mode          string `yaml:"mode"`
+	MapIdentifier string `yaml:"mapIdentifier"`
+}
+
+// SecurityPassedVariable holds the (1-based) index of the security requirement alternative that succeeded
+const SecurityPassedVariable = "spec2proxy.security.passed"
+
+// SecurityCheck is the result of generating the policies for a single security scheme
+type SecurityCheck struct {
+	// Steps verify the credentials. The policies continue on error, so that other alternatives can be tried.
+	Steps []*v1.Step
+
+	// Success is the condition that is true when the verification succeeded
+	Success string
+
+	// After are the steps to run only when the alternative that contains the scheme succeeded
+	After []*v1.Step
+
+	// Challenge is the WWW-Authenticate challenge for the scheme, if any
+	Challenge string
+}
+
+// AddSecurity generates the policies that enforce the spec security requirements.
+// Operations that declare their own requirements have them enforced within the operation's flow. Global requirements
+// are enforced within all the other flows, including a catch-all flow for the requests that do not match any flow.
+// Operations with an empty list of requirements are exempt.
+func AddSecurity(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, options *Options) error {
+	if !options.Security {
+		return nil
+	}
+
+	var err error
+	var steps []*v1.Step
+	for _, flow := range proxyEndpoint.Flows {
+		if flow.SecurityRequirement == nil {
+			continue
+		}
+
+		//the flow overrides the global requirements
+		if steps, err = SecuritySteps(apiProxy, flow.SecurityRequirement); err != nil {
+			return err
+		}
+		flow.Request = append(steps, flow.Request...)
+	}
+
+	if steps, err = SecuritySteps(apiProxy, proxyEndpoint.SecurityRequirement); err != nil {
+		return err
+	}
+
+	if len(steps) == 0 {
+		return nil
+	}
+
+	//the flows that respond within the proxy (e.g. HTTP 405) do not need to be checked, since they never reach the target
+	exempt := func(flow *v1.ConditionalFlow) bool {
+		return flow.SecurityRequirement != nil || RespondsWithinProxy(flow)
+	}
+
+	for _, flow := range DefaultFlows(proxyEndpoint, exempt) {
+		flow.Request = append(CloneSteps(steps), flow.Request...)
+	}
+	return nil
+}
+
+// SecuritySteps returns the steps needed to enforce a list of security requirements.
+//
+// The list is an OR of alternatives, and each alternative is an AND of security schemes.
+// The alternatives are tried in order, until one of them fully succeeds. Then, the index of that alternative
+// is stored in the SecurityPassedVariable, and the remaining alternatives are skipped.
+// If no alternative succeeds, the request is rejected with a single HTTP 401.
+// If any of the alternatives is empty, security is optional, and no steps are needed.
+func SecuritySteps(apiProxy *v1.APIProxy, requirements []*base.SecurityRequirement) ([]*v1.Step, error) {
+	if len(requirements) == 0 {
+		return nil, nil
+	}
+
+	for _, requirement := range requirements {
+		if requirement.ContainsEmptyRequirement || requirement.Requirements == nil || requirement.Requirements.Len() == 0 {
+			return nil, nil
+		}
+	}
+
+	var err error
+	var steps []*v1.Step
+	var challenges []string
+	notPassed := fmt.Sprintf("%s = null", SecurityPassedVariable)
+
+	for index, requirement := range requirements {
+		alternative := index + 1
+
+		var successConditions []string
+		var alternativeSteps []*v1.Step
+		var afterSteps []*v1.Step
+		for elem := requirement.Requirements.First(); elem != nil; elem = elem.Next() {
+			var check *SecurityCheck
+			if check, err = securitySchemeCheck(apiProxy, elem.Key(), elem.Value()); err != nil {
+				return nil, err
+			}
+
+			alternativeSteps = append(alternativeSteps, check.Steps...)
+			afterSteps = append(afterSteps, check.After...)
+			successConditions = append(successConditions, fmt.Sprintf("(%s)", check.Success))
+			if check.Challenge != "" {
+				challenges = AppendUnique(challenges, check.Challenge)
+			}
+		}
+
+		passedPolicy := NewAssignMessagePolicy(fmt.Sprintf("AM-SecurityPassed-%d", alternative))
+		passedPolicy.AssignMessage.AssignVariable = &AssignVariable{
+			Name:  SecurityPassedVariable,
+			Value: fmt.Sprintf("%d", alternative),
+		}
+		if _, err = AddPolicy(apiProxy, passedPolicy); err != nil {
+			return nil, err
+		}
+
+		//the first alternative always runs, the others only if the previous ones did not succeed
+		for _, step := range alternativeSteps {
+			step.Step.Condition = andConditions(notPassed, step.Step.Condition)
+			steps = append(steps, step)
+		}
+
+		steps = append(steps, v1.NewStep(passedPolicy.AssignMessage.Name,
+			andConditions(notPassed, strings.Join(successConditions, " and "))))
+
+		passed := fmt.Sprintf("%s = \"%d\"", SecurityPassedVariable, alternative)
+		for _, step := range afterSteps {
+			step.Step.Condition = andConditions(passed, step.Step.Condition)
+			steps = append(steps, step)
+		}
+	}
+
+	var unauthorizedPolicy *RaiseFaultPolicy
+	if unauthorizedPolicy, err = addUnauthorizedPolicy(apiProxy, challenges); err != nil {
+		return nil, err
+	}
+
+	return append(steps, v1.NewStep(unauthorizedPolicy.RaiseFault.Name, notPassed)), nil
+}
+
+// addUnauthorizedPolicy adds a policy that responds HTTP 401 with the given WWW-Authenticate challenges
+func addUnauthorizedPolicy(apiProxy *v1.APIProxy, challenges []string) (*RaiseFaultPolicy, error) {
+	name := "RF-HTTP401"
+	for _, challenge := range challenges {
+		name = fmt.Sprintf("%s-%s", name, strings.Split(challenge, " ")[0])
+	}
+
+	policy := NewRaiseFaultPolicy(name, "401", "Unauthorized")
+	if len(challenges) > 0 {
+		policy.RaiseFault.FaultResponse.Set.Headers = []*Header{NewHeader("WWW-Authenticate", strings.Join(challenges, ", "))}
+	}
+
+	var err error
+	if _, err = AddPolicy(apiProxy, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// securitySchemeCheck generates the policies for a single security scheme, and returns the steps that use them.
+// It returns an error for security schemes that are not supported, so that no scheme of a requirement is silently dropped.
+func securitySchemeCheck(apiProxy *v1.APIProxy, schemeName string, scopes []string) (*SecurityCheck, error) {
+	scheme, found := apiProxy.SecuritySchemes[schemeName]
+	if !found {
+		return nil, errors.Errorf("security scheme '%s' is not defined", schemeName)
+	}
+
+	switch scheme.Type {
+	case "apiKey":
+		return apiKeyCheck(apiProxy, scheme)
+	case "oauth2":
+		return oauth2Check(apiProxy, scheme, scopes)
+	case "openIdConnect":
+		return jwtCheck(apiProxy, scheme)
+	case "mutualTLS":
+		return mutualTLSCheck()
+	case "http":
+		switch strings.ToLower(scheme.Scheme) {
+		case "bearer":
+			if strings.EqualFold(scheme.BearerFormat, "JWT") {
+				return jwtCheck(apiProxy, scheme)
+			}
+			//opaque bearer tokens are verified as OAuth access tokens issued by Apigee
+			return oauth2Check(apiProxy, scheme, nil)
+		case "basic":
+			return basicCheck(apiProxy, scheme)
+		}
+		return nil, errors.Errorf("security scheme '%s' has unsupported http scheme '%s'", scheme.Name, scheme.Scheme)
+	default:
+		return nil, errors.Errorf("security scheme '%s' has unsupported type '%s'", scheme.Name, scheme.Type)
+	}
+}
+
+// mutualTLSCheck checks that the client presented a certificate during the TLS handshake.
+// The certificate itself is validated by the truststore of the virtual host (or the load balancer), which must require client certificates.
+func mutualTLSCheck() (*SecurityCheck, error) {
+	return &SecurityCheck{
+		Success: `(tls.client.s.dn != null) and (tls.client.s.dn != "")`,
+	}, nil
+}
+
+func apiKeyPolicyName(scheme *v1.SecurityScheme) string {
+	return fmt.Sprintf("VA-%s", SchemeId(scheme))
+}
+
+func oauth2PolicyName(scheme *v1.SecurityScheme) string {
+	return fmt.Sprintf("OA-VerifyAccessToken-%s", SchemeId(scheme))
+}
+
+func apiKeyCookieVariable(scheme *v1.SecurityScheme) string {
+	return fmt.Sprintf("spec2proxy.security.%s.apikey", SchemeId(scheme))
+}
+
+func apiKeyCheck(apiProxy *v1.APIProxy, scheme *v1.SecurityScheme) (*SecurityCheck, error) {
+	var err error
+	check := &SecurityCheck{}
+
+	policy := VerifyAPIKeyPolicy{}
+	policy.VerifyAPIKey.Name = apiKeyPolicyName(scheme)
+	policy.VerifyAPIKey.ContinueOnError = "true"
+	policy.VerifyAPIKey.DisplayName = policy.VerifyAPIKey.Name
+
+	switch scheme.In {
+	case "header":
+		policy.VerifyAPIKey.APIKey.Ref = fmt.Sprintf("request.header.%s", scheme.ParamName)
+	case "query":
+		policy.VerifyAPIKey.APIKey.Ref = fmt.Sprintf("request.queryparam.%s", scheme.ParamName)
+	case "cookie":
+		//there is no flow variable for individual cookies, so the key is extracted from the Cookie header
+		variable := apiKeyCookieVariable(scheme)
+		cookiePolicy := NewAssignMessagePolicy(fmt.Sprintf("AM-APIKeyCookie-%s", SchemeId(scheme)))
+		cookiePolicy.AssignMessage.AssignVariable = &AssignVariable{
+			Name:     variable,
+			Template: fmt.Sprintf(`{replaceFirst(request.header.cookie,"^(.*; *)?%s=([^;]*).*$","$2")}`, escapeRegexLiteral(scheme.ParamName)),
+		}
+		if _, err = AddPolicy(apiProxy, cookiePolicy); err != nil {
+			return nil, err
+		}
+		check.Steps = append(check.Steps, v1.NewStep(cookiePolicy.AssignMessage.Name, "true"))
+		policy.VerifyAPIKey.APIKey.Ref = variable
+	default:
+		return nil, errors.Errorf("security scheme '%s' has unsupported location '%s'", scheme.Name, scheme.In)
+	}
+
+	if _, err = AddPolicy(apiProxy, policy); err != nil {
+		return nil, err
+	}
+
+	check.Steps = append(check.Steps, v1.NewStep(policy.VerifyAPIKey.Name, "true"))
+	check.Success = fmt.Sprintf("not (verifyapikey.%s.failed = true)", policy.VerifyAPIKey.Name)
+	return check, nil
+}
+
+// oauth2Check verifies the access token, and then checks that the token has all the required scopes.
+// The scopes are checked separately, because the OAuthV2 policy succeeds if any (not all) of the scopes is present.
+func oauth2Check(apiProxy *v1.APIProxy, scheme *v1.SecurityScheme, scopes []string) (*SecurityCheck, error) {
+	var err error
+
+	policy := OAuthV2Policy{}
+	policy.OAuthV2.Name = oauth2PolicyName(scheme)
+	policy.OAuthV2.ContinueOnError = "true"
+	policy.OAuthV2.DisplayName = policy.OAuthV2.Name
+	policy.OAuthV2.Operation = "VerifyAccessToken"
+
+	if _, err = AddPolicy(apiProxy, policy); err != nil {
+		return nil, err
+	}
+
+	success := []string{fmt.Sprintf("(not (oauthV2.%s.failed = true))", policy.OAuthV2.Name)}
+	for _, scope := range scopes {
+		success = append(success, fmt.Sprintf("(scope JavaRegex \"(.* )?%s( .*)?\")", escapeRegexLiteral(scope)))
+	}
+
+	return &SecurityCheck{
+		Steps:     []*v1.Step{v1.NewStep(policy.OAuthV2.Name, "true")},
+		Success:   strings.Join(success, " and "),
+		Challenge: "Bearer",
+	}, nil
+}
+
+// jwtCheck verifies the JWT from the Authorization header. The JWKS URI, issuer and audience come from
+// the "x-Apigee-JWT" extension. For openIdConnect schemes, the issuer defaults to the one implied by the
+// discovery URL, which is also used as the display name of the policy. The discovery document itself is never fetched,
+// so the JWKS URI must always be provided. Without a Source, the policy reads the token from the Authorization header,
+// and strips the "Bearer" prefix.
+func jwtCheck(apiProxy *v1.APIProxy, scheme *v1.SecurityScheme) (*SecurityCheck, error) {
+	var err error
+
+	config := &JWTExtension{}
+	if extension, found := scheme.Extensions["x-Apigee-JWT"]; found && extension.Value != nil {
+		if err = extension.Value.Decode(config); err != nil {
+			return nil, errors.New(err)
+		}
+	}
+
+	if config.Issuer == "" && scheme.OpenIdConnectUrl != "" {
+		config.Issuer = strings.TrimSuffix(scheme.OpenIdConnectUrl, "/.well-known/openid-configuration")
+	}
+
+	if config.JWKSUri == "" {
+		return nil, errors.Errorf("security scheme '%s' requires the 'jwksUri' field in the x-Apigee-JWT extension", scheme.Name)
+	}
+
+	if config.Algorithm == "" {
+		config.Algorithm = "RS256"
+	}
+
+	policy := VerifyJWTPolicy{}
+	policy.VerifyJWT.Name = fmt.Sprintf("JWT-Verify-%s", SchemeId(scheme))
+	policy.VerifyJWT.ContinueOnError = "true"
+	policy.VerifyJWT.DisplayName = policy.VerifyJWT.Name
+	policy.VerifyJWT.Algorithm = config.Algorithm
+	if scheme.OpenIdConnectUrl != "" {
+		policy.VerifyJWT.DisplayName = fmt.Sprintf("Verify JWT from %s", scheme.OpenIdConnectUrl)
+	}
+
+	policy.VerifyJWT.PublicKey.JWKS.URI = config.JWKSUri
+	policy.VerifyJWT.Issuer = config.Issuer
+	policy.VerifyJWT.Audience = config.Audience
+
+	if _, err = AddPolicy(apiProxy, policy); err != nil {
+		return nil, err
+	}
+
+	return &SecurityCheck{
+		Steps:     []*v1.Step{v1.NewStep(policy.VerifyJWT.Name, "true")},
+		Success:   fmt.Sprintf("jwt.%s.valid = true", policy.VerifyJWT.Name),
+		Challenge: "Bearer",
+	}, nil
+}
+
+// basicCheck decodes the HTTP Basic credentials into flow variables (spec2proxy.security.<scheme>.username and password).
+// Depending on the mode in the "x-Apigee-BasicAuth" extension, the credentials are then forwarded as-is, checked against a KVM,
+// or checked against a KVM and re-encoded with the backend credentials from the same KVM.
+func basicCheck(apiProxy *v1.APIProxy, scheme *v1.SecurityScheme) (*SecurityCheck, error) {
+	var err error
+
+	config := &BasicAuthExtension{}
+	if extension, found := scheme.Extensions["x-Apigee-BasicAuth"]; found && extension.Value != nil {
+		if err = extension.Value.Decode(config); err != nil {
+			return nil, errors.New(err)
+		}
+	}
+
+	if config.Mode == "" {
+		config.Mode = BasicAuthForward
+	}
+
+	if config.MapIdentifier == "" {
+		config.MapIdentifier = "basic-auth"
+	}
+
+	schemeId := SchemeId(scheme)
+	usernameVar := fmt.Sprintf("spec2proxy.security.%s.username", schemeId)
+	passwordVar := fmt.Sprintf("private.spec2proxy.security.%s.password", schemeId)
+	hasCredentials := `request.header.Authorization JavaRegex "[Bb]asic .+"`
+
+	decodePolicy := BasicAuthenticationPolicy{}
+	decodePolicy.BasicAuthentication.Name = fmt.Sprintf("BA-Decode-%s", schemeId)
+	decodePolicy.BasicAuthentication.ContinueOnError = "true"
+	decodePolicy.BasicAuthentication.DisplayName = decodePolicy.BasicAuthentication.Name
+	decodePolicy.BasicAuthentication.Operation = "Decode"
+	decodePolicy.BasicAuthentication.IgnoreUnresolvedVariables = "false"
+	decodePolicy.BasicAuthentication.User.Ref = usernameVar
+	decodePolicy.BasicAuthentication.Password.Ref = passwordVar
+	decodePolicy.BasicAuthentication.Source = "request.header.Authorization"
+
+	policies := []any{decodePolicy}
+	check := &SecurityCheck{
+		Steps:     []*v1.Step{v1.NewStep(decodePolicy.BasicAuthentication.Name, hasCredentials)},
+		Success:   fmt.Sprintf("(%s) and (%s != null)", hasCredentials, usernameVar),
+		Challenge: fmt.Sprintf(`Basic realm="%s"`, apiProxy.Name),
+	}
+
+	switch config.Mode {
+	case BasicAuthForward:
+	case BasicAuthKVM, BasicAuthReencode:
+		//the KVM entries are keyed by username and the SHA-256 hex of the password, so that passwords are not stored
+		hashVar := fmt.Sprintf("private.spec2proxy.security.%s.hash", schemeId)
+		validVar := fmt.Sprintf("private.spec2proxy.security.%s.valid", schemeId)
+
+		hashPolicy := NewAssignMessagePolicy(fmt.Sprintf("AM-HashPassword-%s", schemeId))
+		hashPolicy.AssignMessage.AssignVariable = &AssignVariable{
+			Name:     hashVar,
+			Template: fmt.Sprintf("{sha256Hex(%s)}", passwordVar),
+		}
+
+		kvmPolicy := KeyValueMapOperationsPolicy{}
+		kvmPolicy.KeyValueMapOperations.Name = fmt.Sprintf("KVM-BasicAuth-%s", schemeId)
+		kvmPolicy.KeyValueMapOperations.ContinueOnError = "true"
+		kvmPolicy.KeyValueMapOperations.DisplayName = kvmPolicy.KeyValueMapOperations.Name
+		kvmPolicy.KeyValueMapOperations.MapIdentifier = config.MapIdentifier
+		kvmPolicy.KeyValueMapOperations.Scope = "environment"
+		kvmPolicy.KeyValueMapOperations.ExpiryTimeInSecs = "300"
+		kvmPolicy.KeyValueMapOperations.Operations = []*KVMGet{NewKVMGet(validVar, KVMRef(usernameVar), KVMRef(hashVar))}
+
+		policies = append(policies, hashPolicy, kvmPolicy)
+		check.Steps = append(check.Steps,
+			v1.NewStep(hashPolicy.AssignMessage.Name, hasCredentials),
+			v1.NewStep(kvmPolicy.KeyValueMapOperations.Name, hasCredentials))
+		check.Success = fmt.Sprintf("%s and (%s != null) and (%s != \"\")", check.Success, validVar, validVar)
+
+		if config.Mode == BasicAuthKVM {
+			break
+		}
+
+		backendUsernameVar := fmt.Sprintf("private.spec2proxy.security.%s.backend.username", schemeId)
+		backendPasswordVar := fmt.Sprintf("private.spec2proxy.security.%s.backend.password", schemeId)
+
+		backendKVMPolicy := KeyValueMapOperationsPolicy{}
+		backendKVMPolicy.KeyValueMapOperations.Name = fmt.Sprintf("KVM-BackendCredentials-%s", schemeId)
+		backendKVMPolicy.KeyValueMapOperations.DisplayName = backendKVMPolicy.KeyValueMapOperations.Name
+		backendKVMPolicy.KeyValueMapOperations.MapIdentifier = config.MapIdentifier
+		backendKVMPolicy.KeyValueMapOperations.Scope = "environment"
+		backendKVMPolicy.KeyValueMapOperations.ExpiryTimeInSecs = "300"
+		backendKVMPolicy.KeyValueMapOperations.Operations = []*KVMGet{
+			NewKVMGet(backendUsernameVar, KVMValue("backend-username")),
+			NewKVMGet(backendPasswordVar, KVMValue("backend-password")),
+		}
+
+		encodePolicy := BasicAuthenticationPolicy{}
+		encodePolicy.BasicAuthentication.Name = fmt.Sprintf("BA-Encode-%s", schemeId)
+		encodePolicy.BasicAuthentication.DisplayName = encodePolicy.BasicAuthentication.Name
+		encodePolicy.BasicAuthentication.Operation = "Encode"
+		encodePolicy.BasicAuthentication.IgnoreUnresolvedVariables = "false"
+		encodePolicy.BasicAuthentication.User.Ref = backendUsernameVar
+		encodePolicy.BasicAuthentication.Password.Ref = backendPasswordVar
+		encodePolicy.BasicAuthentication.AssignTo = &struct {
+			CreateNew string `yaml:".createNew"`
+			Value     string `yaml:".@"`
+		}{CreateNew: "false", Value: "request.header.Authorization"}
+
+		//the backend credentials replace the client credentials only once the client credentials have been accepted
+		policies = append(policies, backendKVMPolicy, encodePolicy)
+		check.After = append(check.After,
+			v1.NewStep(backendKVMPolicy.KeyValueMapOperations.Name, "true"),
+			v1.NewStep(encodePolicy.BasicAuthentication.Name, "true"))
+	default:
+		return nil, errors.Errorf("security scheme '%s' has unsupported x-Apigee-BasicAuth mode '%s'", scheme.Name, config.Mode)
+	}
+
+	for _, policy := range policies {
+		if _, err = AddPolicy(apiProxy, policy); err != nil {
+			return nil, err
+		}
+	}
+
+	return check, nil
+}
+
+// SchemeId returns an identifier for the security scheme that is safe to use within policy names
+func SchemeId(scheme *v1.SecurityScheme) string {
+	return strings.Trim(unsafeNameCharsRegex.ReplaceAllString(scheme.Name, "-"), "-")
+}
+
+// CredentialRefs returns the flow variables with the credentials presented for the schemes of the security requirements
+// (e.g. the API key, or the Authorization header), which identify the caller
+func CredentialRefs(apiProxy *v1.APIProxy, requirements []*base.SecurityRequirement) []string {
+	var refs []string
+	for _, requirement := range requirements {
+		if requirement.Requirements == nil {
+			continue
+		}
+
+		for elem := requirement.Requirements.First(); elem != nil; elem = elem.Next() {
+			scheme, found := apiProxy.SecuritySchemes[elem.Key()]
+			if !found {
+				continue
+			}
+
+			switch {
+			case scheme.Type == "apiKey" && scheme.In == "header":
+				refs = AppendUnique(refs, fmt.Sprintf("request.header.%s", scheme.ParamName))
+			case scheme.Type == "apiKey" && scheme.In == "query":
+				refs = AppendUnique(refs, fmt.Sprintf("request.queryparam.%s", scheme.ParamName))
+			case scheme.Type == "apiKey" && scheme.In == "cookie":
+				refs = AppendUnique(refs, apiKeyCookieVariable(scheme))
+			case scheme.Type == "mutualTLS":
+				refs = AppendUnique(refs, "tls.client.s.dn")
+			case scheme.Type == "oauth2" || scheme.Type == "openIdConnect" || scheme.Type == "http":
+				refs = AppendUnique(refs, "request.header.Authorization")
+			}
+		}
+	}
+	return refs
+}
@@ -0,0 +1,249 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"fmt"
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"github.com/pb33f/libopenapi/datamodel/high/base"
+	"github.com/pb33f/libopenapi/orderedmap"
+	"gopkg.in/yaml.v3"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// requirement builds a security requirement from entries made of a scheme name followed by its scopes (e.g. "oauth read write")
+func requirement(entries ...string) *base.SecurityRequirement {
+	requirements := orderedmap.New[string, []string]()
+	for _, entry := range entries {
+		fields := strings.Fields(entry)
+		requirements.Set(fields[0], fields[1:])
+	}
+	return &base.SecurityRequirement{Requirements: requirements, ContainsEmptyRequirement: len(entries) == 0}
+}
+
+func securityTestProxy() *v1.APIProxy {
+	return &v1.APIProxy{
+		Name: "test",
+		SecuritySchemes: map[string]*v1.SecurityScheme{
+			"apiKey": {Name: "apiKey", Type: "apiKey", In: "header", ParamName: "x-api-key"},
+			"oauth":  {Name: "oauth", Type: "oauth2"},
+			"mtls":   {Name: "mtls", Type: "mutualTLS"},
+			"bearer": {Name: "bearer", Type: "http", Scheme: "bearer"},
+			"digest": {Name: "digest", Type: "http", Scheme: "digest"},
+		},
+	}
+}
+
+func TestSecuritySteps(t *testing.T) {
+	const notPassed = `spec2proxy.security.passed = null`
+	const apiKeySuccess = `(not (verifyapikey.VA-apiKey.failed = true))`
+	const oauthSuccess = `((not (oauthV2.OA-VerifyAccessToken-oauth.failed = true)))`
+	const mtlsSuccess = `((tls.client.s.dn != null) and (tls.client.s.dn != ""))`
+
+	tests := []struct {
+		name         string
+		requirements []*base.SecurityRequirement
+		steps        []string
+		err          string
+	}{
+		{
+			name: "no requirements",
+		},
+		{
+			name:         "optional security",
+			requirements: []*base.SecurityRequirement{requirement("apiKey"), requirement()},
+		},
+		{
+			name:         "single scheme",
+			requirements: []*base.SecurityRequirement{requirement("apiKey")},
+			steps: []string{
+				fmt.Sprintf("VA-apiKey | %s", notPassed),
+				fmt.Sprintf("AM-SecurityPassed-1 | (%s) and (%s)", notPassed, apiKeySuccess),
+				fmt.Sprintf("RF-HTTP401 | %s", notPassed),
+			},
+		},
+		{
+			name:         "apiKey OR oauth",
+			requirements: []*base.SecurityRequirement{requirement("apiKey"), requirement("oauth")},
+			steps: []string{
+				fmt.Sprintf("VA-apiKey | %s", notPassed),
+				fmt.Sprintf("AM-SecurityPassed-1 | (%s) and (%s)", notPassed, apiKeySuccess),
+				fmt.Sprintf("OA-VerifyAccessToken-oauth | %s", notPassed),
+				fmt.Sprintf("AM-SecurityPassed-2 | (%s) and (%s)", notPassed, oauthSuccess),
+				fmt.Sprintf("RF-HTTP401-Bearer | %s", notPassed),
+			},
+		},
+		{
+			name:         "apiKey AND mTLS",
+			requirements: []*base.SecurityRequirement{requirement("apiKey", "mtls")},
+			steps: []string{
+				fmt.Sprintf("VA-apiKey | %s", notPassed),
+				fmt.Sprintf("AM-SecurityPassed-1 | (%s) and (%s and %s)", notPassed, apiKeySuccess, mtlsSuccess),
+				fmt.Sprintf("RF-HTTP401 | %s", notPassed),
+			},
+		},
+		{
+			name:         "(apiKey AND mTLS) OR oauth with scopes",
+			requirements: []*base.SecurityRequirement{requirement("apiKey", "mtls"), requirement("oauth read")},
+			steps: []string{
+				fmt.Sprintf("VA-apiKey | %s", notPassed),
+				fmt.Sprintf("AM-SecurityPassed-1 | (%s) and (%s and %s)", notPassed, apiKeySuccess, mtlsSuccess),
+				fmt.Sprintf("OA-VerifyAccessToken-oauth | %s", notPassed),
+				fmt.Sprintf(`AM-SecurityPassed-2 | (%s) and (((not (oauthV2.OA-VerifyAccessToken-oauth.failed = true)) and (scope JavaRegex "(.* )?read( .*)?")))`, notPassed),
+				fmt.Sprintf("RF-HTTP401-Bearer | %s", notPassed),
+			},
+		},
+		{
+			name:         "opaque bearer token",
+			requirements: []*base.SecurityRequirement{requirement("bearer")},
+			steps: []string{
+				fmt.Sprintf("OA-VerifyAccessToken-bearer | %s", notPassed),
+				fmt.Sprintf("AM-SecurityPassed-1 | (%s) and (((not (oauthV2.OA-VerifyAccessToken-bearer.failed = true))))", notPassed),
+				fmt.Sprintf("RF-HTTP401-Bearer | %s", notPassed),
+			},
+		},
+		{
+			name:         "unsupported scheme",
+			requirements: []*base.SecurityRequirement{requirement("apiKey", "digest")},
+			err:          "security scheme 'digest' has unsupported http scheme 'digest'",
+		},
+		{
+			name:         "undefined scheme",
+			requirements: []*base.SecurityRequirement{requirement("missing")},
+			err:          "security scheme 'missing' is not defined",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			steps, err := SecuritySteps(securityTestProxy(), test.requirements)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var actual []string
+			for _, step := range steps {
+				actual = append(actual, fmt.Sprintf("%s | %s", step.Step.Name, step.Step.Condition))
+			}
+
+			if !slices.Equal(actual, test.steps) {
+				t.Fatalf("unexpected steps\nexpected:\n%s\nactual:\n%s", strings.Join(test.steps, "\n"), strings.Join(actual, "\n"))
+			}
+		})
+	}
+}
+
+func jwtTestScheme(extension string) *v1.SecurityScheme {
+	scheme := &v1.SecurityScheme{Name: "jwt", Type: "http", Scheme: "bearer", BearerFormat: "JWT", Extensions: map[string]*v1.Extension{}}
+	if extension != "" {
+		node := &yaml.Node{}
+		if err := yaml.Unmarshal([]byte(extension), node); err != nil {
+			panic(err)
+		}
+		scheme.Extensions["x-Apigee-JWT"] = &v1.Extension{Name: "x-Apigee-JWT", Value: node.Content[0]}
+	}
+	return scheme
+}
+
+func TestJWTCheck(t *testing.T) {
+	apiProxy := securityTestProxy()
+	if _, err := jwtCheck(apiProxy, jwtTestScheme("")); err == nil || err.Error() != "security scheme 'jwt' requires the 'jwksUri' field in the x-Apigee-JWT extension" {
+		t.Fatalf("expected an error for the missing jwksUri, got %v", err)
+	}
+
+	if _, err := jwtCheck(apiProxy, jwtTestScheme("{jwksUri: https://example.com/jwks}")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(apiProxy.Policies) != 1 {
+		t.Fatalf("expected a single policy, got %d", len(apiProxy.Policies))
+	}
+
+	policyYAML, err := yaml.Marshal(apiProxy.Policies[0].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//with an explicit Source, VerifyJWT does not strip the "Bearer" prefix of the Authorization header
+	if strings.Contains(string(policyYAML), "Source") {
+		t.Errorf("expected the VerifyJWT policy to read the token from its default source, got:\n%s", policyYAML)
+	}
+
+	if !strings.Contains(string(policyYAML), "https://example.com/jwks") {
+		t.Errorf("expected the VerifyJWT policy to use the JWKS URI, got:\n%s", policyYAML)
+	}
+}
+
+// TestAddSecurityFlows checks which flows get the global security steps. The operations without their own requirements,
+// and the catch-all flow (for the requests that do not match any operation, which go to the target) are checked.
+// The operations with their own requirements are checked against them instead, and the generated flows that respond within
+// the proxy (e.g. OPTIONS and HTTP 405) are not checked, since they never reach the target.
+func TestAddSecurityFlows(t *testing.T) {
+	apiProxy := securityTestProxy()
+	proxyEndpoint := &v1.ProxyEndpoint{
+		BasePath:            "/v1",
+		SecurityRequirement: []*base.SecurityRequirement{requirement("apiKey")},
+		Flows: []*v1.ConditionalFlow{
+			{Name: "listPets", Verb: "GET", Path: "/pets"},
+			{Name: "getPet", Verb: "GET", Path: "/pets/{id}", SecurityRequirement: []*base.SecurityRequirement{requirement()}},
+			{Name: "createPet", Verb: "POST", Path: "/pets", SecurityRequirement: []*base.SecurityRequirement{requirement("oauth")}},
+			{Name: "options-v1-pets", Path: "/pets"},
+			{Name: "method-not-allowed-v1-pets", Path: "/pets"},
+			{Name: "trailing-slash-redirect"},
+		},
+	}
+
+	if err := AddSecurity(apiProxy, proxyEndpoint, NewOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"listPets":                   "VA-apiKey",
+		"getPet":                     "",
+		"createPet":                  "OA-VerifyAccessToken-oauth",
+		"options-v1-pets":            "",
+		"method-not-allowed-v1-pets": "",
+		"trailing-slash-redirect":    "",
+		CatchAllFlowName:             "VA-apiKey",
+	}
+
+	if len(proxyEndpoint.Flows) != len(expected) {
+		t.Fatalf("expected %d flows (with a single catch-all flow), got %d", len(expected), len(proxyEndpoint.Flows))
+	}
+
+	for _, flow := range proxyEndpoint.Flows {
+		var first string
+		if len(flow.Request) > 0 {
+			first = flow.Request[0].Step.Name
+		}
+
+		if first != expected[flow.Name] {
+			t.Errorf("expected the first step of flow '%s' to be '%s', got '%s'", flow.Name, expected[flow.Name], first)
+		}
+	}
+
+	if last := proxyEndpoint.Flows[len(proxyEndpoint.Flows)-1]; last.Name != CatchAllFlowName || last.Condition != "" {
+		t.Errorf("expected the unconditional catch-all flow to be the last one, got '%s'", last.Name)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"github.com/go-errors/errors"
+	v1 "github.com/micovery/spec2proxy/pkg/apigee/v1"
+)
+
+// GoogleAuthExtension is the value of the "x-Apigee-GoogleAuth" extension, at the top level of the spec
+type GoogleAuthExtension struct {
+	Type              string   `yaml:"type"`
+	Audience          string   `yaml:"audience"`
+	IncludeEmail      bool     `yaml:"includeEmail"`
+	Scopes            []string `yaml:"scopes"`
+	LifetimeInSeconds int      `yaml:"lifetimeInSeconds"`
+}
+
+// BuildTargetAuthentication returns the Google authentication settings for target requests.
+// The "x-Apigee-GoogleAuth" extension takes precedence over the GoogleAuth options.
+// It returns nil when target requests are not authenticated.
+func BuildTargetAuthentication(extensions map[string]*v1.Extension, options *Options) (*v1.Authentication, error) {
+	config := &GoogleAuthExtension{
+		Type:     options.GoogleAuth,
+		Audience: options.GoogleAudience,
+		Scopes:   options.GoogleScopes,
+	}
+
+	if extension, found := extensions["x-Apigee-GoogleAuth"]; found && extension.Value != nil {
+		if err := extension.Value.Decode(config); err != nil {
+			return nil, errors.New(err)
+		}
+	}
+
+	switch config.Type {
+	case "":
+		return nil, nil
+	case GoogleAuthIDToken:
+		//without an explicit audience, Apigee uses the target URL (which is what Cloud Run expects)
+		return &v1.Authentication{
+			GoogleIDToken: &v1.GoogleIDToken{
+				Audience:     config.Audience,
+				UseTargetUrl: config.Audience == "",
+				IncludeEmail: config.IncludeEmail,
+			},
+		}, nil
+	case GoogleAuthAccessToken:
+		var scopes []string
+		for _, scope := range config.Scopes {
+			if scope != "" {
+				scopes = append(scopes, scope)
+			}
+		}
+
+		if len(scopes) == 0 {
+			return nil, errors.Errorf("google access tokens require at least one scope")
+		}
+
+		return &v1.Authentication{
+			GoogleAccessToken: &v1.GoogleAccessToken{
+				Scopes:            scopes,
+				LifetimeInSeconds: config.LifetimeInSeconds,
+			},
+		}, nil
+	default:
+		return nil, errors.Errorf("x-Apigee-GoogleAuth type '%s' is not supported", config.Type)
+	}
+}
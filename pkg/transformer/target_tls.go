@@ -0,0 +1,84 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"github.com/go-errors/errors"
+	v1 "github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"net/url"
+)
+
+// TargetTLSExtension is the value of the "x-Apigee-TargetTLS" extension, at the top level of the spec
+type TargetTLSExtension struct {
+	KeyStore               string `yaml:"keyStore"`
+	KeyAlias               string `yaml:"keyAlias"`
+	TrustStore             string `yaml:"trustStore"`
+	ClientAuthEnabled      bool   `yaml:"clientAuthEnabled"`
+	CommonName             string `yaml:"commonName"`
+	WildcardMatch          bool   `yaml:"wildcardMatch"`
+	IgnoreValidationErrors *bool  `yaml:"ignoreValidationErrors"`
+}
+
+// BuildSSLInfo returns the TLS settings for the target URL.
+// By default, the backend certificate is validated, and its common name must match the target host.
+// The "x-Apigee-TargetTLS" extension adds the key store and trust store references needed for mTLS, and
+// the InsecureTargetTLS option (or the extension's ignoreValidationErrors field) turns off certificate validation.
+func BuildSSLInfo(targetUrl string, extensions map[string]*v1.Extension, options *Options) (v1.SSLInfo, error) {
+	var err error
+	var parsedUrl *url.URL
+	if parsedUrl, err = url.Parse(targetUrl); err != nil {
+		return v1.SSLInfo{}, errors.New(err)
+	}
+
+	if parsedUrl.Scheme == "http" {
+		return v1.SSLInfo{Enabled: false}, nil
+	}
+
+	config := &TargetTLSExtension{}
+	if extension, found := extensions["x-Apigee-TargetTLS"]; found && extension.Value != nil {
+		if err = extension.Value.Decode(config); err != nil {
+			return v1.SSLInfo{}, errors.New(err)
+		}
+	}
+
+	if config.ClientAuthEnabled && (config.KeyStore == "" || config.KeyAlias == "") {
+		return v1.SSLInfo{}, errors.Errorf("x-Apigee-TargetTLS requires 'keyStore' and 'keyAlias' when 'clientAuthEnabled' is true")
+	}
+
+	ignoreValidationErrors := options.InsecureTargetTLS
+	if config.IgnoreValidationErrors != nil {
+		ignoreValidationErrors = *config.IgnoreValidationErrors
+	}
+
+	sslInfo := v1.SSLInfo{
+		Enabled:                true,
+		Enforce:                !ignoreValidationErrors,
+		ClientAuthEnabled:      config.ClientAuthEnabled,
+		KeyStore:               config.KeyStore,
+		KeyAlias:               config.KeyAlias,
+		TrustStore:             config.TrustStore,
+		IgnoreValidationErrors: ignoreValidationErrors,
+	}
+
+	if !ignoreValidationErrors {
+		sslInfo.CommonName = config.CommonName
+		if sslInfo.CommonName == "" {
+			sslInfo.CommonName = parsedUrl.Hostname()
+		}
+		sslInfo.WildcardMatch = config.WildcardMatch
+	}
+
+	return sslInfo, nil
+}
@@ -0,0 +1,408 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"fmt"
+	"github.com/go-errors/errors"
+	v1 "github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"github.com/pb33f/libopenapi/datamodel/high/base"
+	"github.com/pb33f/libopenapi/orderedmap"
+	"slices"
+	"strings"
+)
+
+// JSONLimits are the limits enforced by the JSONThreatProtection policy. Zero means no limit.
+type JSONLimits struct {
+	ContainerDepth        int `yaml:"containerDepth"`
+	ArrayElementCount     int `yaml:"arrayElementCount"`
+	ObjectEntryCount      int `yaml:"objectEntryCount"`
+	ObjectEntryNameLength int `yaml:"objectEntryNameLength"`
+	StringValueLength     int `yaml:"stringValueLength"`
+}
+
+// XMLLimits are the limits enforced by the XMLThreatProtection policy. Zero means no limit.
+type XMLLimits struct {
+	NodeDepth                int `yaml:"nodeDepth"`
+	ChildCount               int `yaml:"childCount"`
+	AttributeCountPerElement int `yaml:"attributeCountPerElement"`
+	NamespaceCountPerElement int `yaml:"namespaceCountPerElement"`
+	ElementNameLength        int `yaml:"elementNameLength"`
+	AttributeNameLength      int `yaml:"attributeNameLength"`
+	TextLength               int `yaml:"textLength"`
+	AttributeValueLength     int `yaml:"attributeValueLength"`
+}
+
+// ThreatProtectionExtension is the value of the "x-Apigee-ThreatProtection" extension, either at the top level of the spec,
+// or within an operation. The limits are used when the request body schema does not constrain the corresponding value.
+type ThreatProtectionExtension struct {
+	Enabled *bool      `yaml:"enabled"`
+	JSON    JSONLimits `yaml:"json"`
+	XML     XMLLimits  `yaml:"xml"`
+}
+
+type JSONThreatProtectionPolicy struct {
+	JSONThreatProtection struct {
+		Name                  string `yaml:".name"`
+		DisplayName           string `yaml:"DisplayName"`
+		Source                string `yaml:"Source"`
+		ContainerDepth        string `yaml:"ContainerDepth,omitempty"`
+		ArrayElementCount     string `yaml:"ArrayElementCount,omitempty"`
+		ObjectEntryCount      string `yaml:"ObjectEntryCount,omitempty"`
+		ObjectEntryNameLength string `yaml:"ObjectEntryNameLength,omitempty"`
+		StringValueLength     string `yaml:"StringValueLength,omitempty"`
+	} `yaml:"JSONThreatProtection"`
+}
+
+type XMLChildCount struct {
+	IncludeComment               string `yaml:".includeComment"`
+	IncludeElement               string `yaml:".includeElement"`
+	IncludeProcessingInstruction string `yaml:".includeProcessingInstruction"`
+	IncludeText                  string `yaml:".includeText"`
+	Value                        string `yaml:".@"`
+}
+
+type XMLNameLimits struct {
+	Element   string `yaml:"Element,omitempty"`
+	Attribute string `yaml:"Attribute,omitempty"`
+}
+
+type XMLStructureLimits struct {
+	NodeDepth                string         `yaml:"NodeDepth,omitempty"`
+	AttributeCountPerElement string         `yaml:"AttributeCountPerElement,omitempty"`
+	NamespaceCountPerElement string         `yaml:"NamespaceCountPerElement,omitempty"`
+	ChildCount               *XMLChildCount `yaml:"ChildCount,omitempty"`
+}
+
+type XMLValueLimits struct {
+	Text      string `yaml:"Text,omitempty"`
+	Attribute string `yaml:"Attribute,omitempty"`
+}
+
+type XMLThreatProtectionPolicy struct {
+	XMLThreatProtection struct {
+		Name            string             `yaml:".name"`
+		DisplayName     string             `yaml:"DisplayName"`
+		Source          string             `yaml:"Source"`
+		NameLimits      XMLNameLimits      `yaml:"NameLimits"`
+		StructureLimits XMLStructureLimits `yaml:"StructureLimits"`
+		ValueLimits     XMLValueLimits     `yaml:"ValueLimits"`
+	} `yaml:"XMLThreatProtection"`
+}
+
+// AddThreatProtection adds JSONThreatProtection and XMLThreatProtection policies to the flows of operations
+// with a JSON or XML request body. The limits are derived from the request body schema.
+func AddThreatProtection(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, options *Options) error {
+	if !options.ThreatProtection {
+		return nil
+	}
+
+	var err error
+	for _, flow := range proxyEndpoint.Flows {
+		if flow.RequestBody == nil || len(flow.RequestBody.Content) == 0 {
+			continue
+		}
+
+		var config *ThreatProtectionExtension
+		if config, err = threatProtectionConfig(apiProxy, flow); err != nil {
+			return err
+		}
+		if config.Enabled != nil && !*config.Enabled {
+			continue
+		}
+
+		var steps []*v1.Step
+		var step *v1.Step
+		if step, err = addJSONThreatProtection(apiProxy, flow, config); err != nil {
+			return err
+		} else if step != nil {
+			steps = append(steps, step)
+		}
+
+		if step, err = addXMLThreatProtection(apiProxy, flow, config); err != nil {
+			return err
+		} else if step != nil {
+			steps = append(steps, step)
+		}
+
+		flow.Request = append(steps, flow.Request...)
+	}
+
+	return nil
+}
+
+func addJSONThreatProtection(apiProxy *v1.APIProxy, flow *v1.ConditionalFlow, config *ThreatProtectionExtension) (*v1.Step, error) {
+	contentTypes, limits := mediaTypeLimits(flow.RequestBody.Content, isJSONContentType)
+	if len(contentTypes) == 0 {
+		return nil, nil
+	}
+
+	policy := JSONThreatProtectionPolicy{}
+	policy.JSONThreatProtection.Name = fmt.Sprintf("JSON-TP-%s", FlowId(flow))
+	policy.JSONThreatProtection.DisplayName = policy.JSONThreatProtection.Name
+	policy.JSONThreatProtection.Source = "request"
+	policy.JSONThreatProtection.ContainerDepth = limits.containerDepth.value(config.JSON.ContainerDepth)
+	policy.JSONThreatProtection.ArrayElementCount = limits.arrayElementCount.value(config.JSON.ArrayElementCount)
+	policy.JSONThreatProtection.ObjectEntryCount = limits.objectEntryCount.value(config.JSON.ObjectEntryCount)
+	policy.JSONThreatProtection.ObjectEntryNameLength = limits.objectEntryNameLength.value(config.JSON.ObjectEntryNameLength)
+	policy.JSONThreatProtection.StringValueLength = limits.stringValueLength.value(config.JSON.StringValueLength)
+
+	//a policy without any limits would not check anything
+	if policy.JSONThreatProtection.ContainerDepth == "" && policy.JSONThreatProtection.ArrayElementCount == "" &&
+		policy.JSONThreatProtection.ObjectEntryCount == "" && policy.JSONThreatProtection.ObjectEntryNameLength == "" &&
+		policy.JSONThreatProtection.StringValueLength == "" {
+		return nil, nil
+	}
+
+	var err error
+	if _, err = AddPolicy(apiProxy, policy); err != nil {
+		return nil, err
+	}
+
+	return v1.NewStep(policy.JSONThreatProtection.Name, contentTypeCondition(contentTypes)), nil
+}
+
+// addXMLThreatProtection maps the schema limits to XML, assuming that properties and array items are child elements
+func addXMLThreatProtection(apiProxy *v1.APIProxy, flow *v1.ConditionalFlow, config *ThreatProtectionExtension) (*v1.Step, error) {
+	contentTypes, limits := mediaTypeLimits(flow.RequestBody.Content, isXMLContentType)
+	if len(contentTypes) == 0 {
+		return nil, nil
+	}
+
+	policy := XMLThreatProtectionPolicy{}
+	policy.XMLThreatProtection.Name = fmt.Sprintf("XML-TP-%s", FlowId(flow))
+	policy.XMLThreatProtection.DisplayName = policy.XMLThreatProtection.Name
+	policy.XMLThreatProtection.Source = "request"
+	policy.XMLThreatProtection.NameLimits.Element = limits.objectEntryNameLength.value(config.XML.ElementNameLength)
+	policy.XMLThreatProtection.NameLimits.Attribute = limitValue(config.XML.AttributeNameLength)
+	policy.XMLThreatProtection.StructureLimits.AttributeCountPerElement = limitValue(config.XML.AttributeCountPerElement)
+	policy.XMLThreatProtection.StructureLimits.NamespaceCountPerElement = limitValue(config.XML.NamespaceCountPerElement)
+	policy.XMLThreatProtection.ValueLimits.Text = limits.stringValueLength.value(config.XML.TextLength)
+	policy.XMLThreatProtection.ValueLimits.Attribute = limitValue(config.XML.AttributeValueLength)
+
+	//the root element adds one level to the document
+	nodeDepth := limits.containerDepth
+	if !nodeDepth.unbounded {
+		nodeDepth.max += 1
+	}
+	policy.XMLThreatProtection.StructureLimits.NodeDepth = nodeDepth.value(config.XML.NodeDepth)
+
+	childCount := limits.objectEntryCount
+	childCount.merge(limits.arrayElementCount)
+	if value := childCount.value(config.XML.ChildCount); value != "" {
+		policy.XMLThreatProtection.StructureLimits.ChildCount = &XMLChildCount{
+			IncludeComment:               "true",
+			IncludeElement:               "true",
+			IncludeProcessingInstruction: "true",
+			IncludeText:                  "true",
+			Value:                        value,
+		}
+	}
+
+	//a policy without any limits would not check anything
+	if policy.XMLThreatProtection.NameLimits == (XMLNameLimits{}) && policy.XMLThreatProtection.StructureLimits == (XMLStructureLimits{}) &&
+		policy.XMLThreatProtection.ValueLimits == (XMLValueLimits{}) {
+		return nil, nil
+	}
+
+	var err error
+	if _, err = AddPolicy(apiProxy, policy); err != nil {
+		return nil, err
+	}
+
+	return v1.NewStep(policy.XMLThreatProtection.Name, contentTypeCondition(contentTypes)), nil
+}
+
+// threatProtectionConfig returns the top-level configuration, overridden by the operation-level one
+func threatProtectionConfig(apiProxy *v1.APIProxy, flow *v1.ConditionalFlow) (*ThreatProtectionExtension, error) {
+	config := &ThreatProtectionExtension{}
+	for _, extensions := range []map[string]*v1.Extension{apiProxy.Extensions, flow.Extensions} {
+		if extension, found := extensions["x-Apigee-ThreatProtection"]; found && extension.Value != nil {
+			if err := extension.Value.Decode(config); err != nil {
+				return nil, errors.New(err)
+			}
+		}
+	}
+	return config, nil
+}
+
+// mediaTypeLimits returns the matching content types, and the combined limits of their schemas
+func mediaTypeLimits(content []*v1.MediaType, matches func(string) bool) ([]string, *schemaLimits) {
+	var contentTypes []string
+	limits := &schemaLimits{}
+	for _, mediaType := range content {
+		if !matches(mediaType.ContentType) {
+			continue
+		}
+		contentTypes = append(contentTypes, mediaType.ContentType)
+		limits.walk(mediaType.Schema, 0, nil)
+	}
+	return contentTypes, limits
+}
+
+func isJSONContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return contentType == "application/json" || strings.HasSuffix(contentType, "+json")
+}
+
+func isXMLContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return contentType == "application/xml" || contentType == "text/xml" || strings.HasSuffix(contentType, "+xml")
+}
+
+// contentTypeCondition matches the requests with any of the content types, ignoring the case and the media type parameters
+// (as the HTTP 415 check does)
+func contentTypeCondition(contentTypes []string) string {
+	var ranges []string
+	for _, contentType := range contentTypes {
+		ranges = AppendUnique(ranges, strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0])))
+	}
+	return fmt.Sprintf(`request.header.Content-Type JavaRegex "%s"`, contentTypeRegex(ranges))
+}
+
+// schemaLimit is the largest value allowed by the schema, or unbounded if the schema does not constrain the value
+type schemaLimit struct {
+	max       int
+	unbounded bool
+}
+
+func (l *schemaLimit) merge(other schemaLimit) {
+	l.unbounded = l.unbounded || other.unbounded
+	l.max = max(l.max, other.max)
+}
+
+func (l *schemaLimit) add(value *int64) {
+	if value == nil {
+		l.unbounded = true
+		return
+	}
+	l.max = max(l.max, int(*value))
+}
+
+// value returns the limit, falling back to the given default when the schema does not constrain the value
+func (l *schemaLimit) value(defaultValue int) string {
+	if l.unbounded || l.max == 0 {
+		return limitValue(defaultValue)
+	}
+	return limitValue(l.max)
+}
+
+func limitValue(value int) string {
+	if value <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d", value)
+}
+
+type schemaLimits struct {
+	containerDepth        schemaLimit
+	arrayElementCount     schemaLimit
+	objectEntryCount      schemaLimit
+	objectEntryNameLength schemaLimit
+	stringValueLength     schemaLimit
+}
+
+// walk visits the schema (at the given container depth) and its sub-schemas, and updates the limits.
+// The refs are the references of the schemas being visited, which are used to detect recursive schemas.
+// Values without a schema make all the limits unbounded. Objects that allow additional properties without a schema
+// make the container depth and object entry limits unbounded, and recursive schemas make the container depth unbounded.
+func (l *schemaLimits) walk(schema *base.Schema, depth int, refs []string) {
+	if schema == nil {
+		l.unbounded()
+		return
+	}
+
+	for _, proxies := range [][]*base.SchemaProxy{schema.AllOf, schema.OneOf, schema.AnyOf} {
+		for _, proxy := range proxies {
+			l.walkProxy(proxy, depth, refs)
+		}
+	}
+
+	switch {
+	case slices.Contains(schema.Type, "object") || schema.Properties != nil:
+		l.containerDepth.max = max(l.containerDepth.max, depth+1)
+		l.objectEntryCount.merge(objectEntryCount(schema))
+
+		closed := schema.AdditionalProperties != nil && schema.AdditionalProperties.IsB() && !schema.AdditionalProperties.B
+		for elem := schema.Properties.First(); elem != nil; elem = elem.Next() {
+			if closed {
+				l.objectEntryNameLength.max = max(l.objectEntryNameLength.max, len(elem.Key()))
+			}
+			l.walkProxy(elem.Value(), depth+1, refs)
+		}
+		if !closed {
+			l.objectEntryNameLength.unbounded = true
+		}
+
+		if schema.AdditionalProperties != nil && schema.AdditionalProperties.IsA() && schema.AdditionalProperties.A != nil {
+			l.walkProxy(schema.AdditionalProperties.A, depth+1, refs)
+		} else if !closed {
+			//additional properties without a schema may nest any value, while the limits declared for the other values still apply
+			l.containerDepth.unbounded = true
+		}
+	case slices.Contains(schema.Type, "array") || schema.Items != nil:
+		l.containerDepth.max = max(l.containerDepth.max, depth+1)
+		l.arrayElementCount.add(schema.MaxItems)
+
+		if schema.Items != nil && schema.Items.IsA() && schema.Items.A != nil {
+			l.walkProxy(schema.Items.A, depth+1, refs)
+		} else {
+			l.walk(nil, depth+1, refs)
+		}
+	case slices.Contains(schema.Type, "string"):
+		l.stringValueLength.add(schema.MaxLength)
+	case len(schema.Type) == 0 && len(schema.AllOf)+len(schema.OneOf)+len(schema.AnyOf) == 0:
+		//any JSON value
+		l.unbounded()
+	}
+}
+
+func (l *schemaLimits) walkProxy(proxy *base.SchemaProxy, depth int, refs []string) {
+	if proxy == nil {
+		l.unbounded()
+		return
+	}
+
+	if proxy.IsReference() {
+		if slices.Contains(refs, proxy.GetReference()) {
+			l.containerDepth.unbounded = true
+			return
+		}
+		refs = append(refs, proxy.GetReference())
+	}
+
+	l.walk(proxy.Schema(), depth, refs)
+}
+
+func (l *schemaLimits) unbounded() {
+	l.containerDepth.unbounded = true
+	l.arrayElementCount.unbounded = true
+	l.objectEntryCount.unbounded = true
+	l.objectEntryNameLength.unbounded = true
+	l.stringValueLength.unbounded = true
+}
+
+// objectEntryCount returns the maximum number of entries for an object schema
+func objectEntryCount(schema *base.Schema) schemaLimit {
+	if schema.MaxProperties != nil {
+		return schemaLimit{max: int(*schema.MaxProperties)}
+	}
+
+	if schema.AdditionalProperties != nil && schema.AdditionalProperties.IsB() && !schema.AdditionalProperties.B {
+		return schemaLimit{max: orderedmap.Len(schema.Properties)}
+	}
+
+	return schemaLimit{unbounded: true}
+}
@@ -0,0 +1,157 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"github.com/pb33f/libopenapi/datamodel/high/base"
+	"github.com/pb33f/libopenapi/orderedmap"
+	"regexp"
+	"testing"
+)
+
+func int64Ptr(value int64) *int64 {
+	return &value
+}
+
+// objectSchema builds an object schema with the given properties, closed when additionalProperties is false
+func objectSchema(additionalProperties *bool, properties map[string]*base.Schema) *base.Schema {
+	schema := &base.Schema{Type: []string{"object"}, Properties: orderedmap.New[string, *base.SchemaProxy]()}
+	for name, property := range properties {
+		schema.Properties.Set(name, base.CreateSchemaProxy(property))
+	}
+	if additionalProperties != nil {
+		schema.AdditionalProperties = &base.DynamicValue[*base.SchemaProxy, bool]{N: 1, B: *additionalProperties}
+	}
+	return schema
+}
+
+func TestSchemaLimits(t *testing.T) {
+	closed := false
+	open := true
+
+	pet := func(additionalProperties *bool) *base.Schema {
+		return objectSchema(additionalProperties, map[string]*base.Schema{
+			"name": {Type: []string{"string"}, MaxLength: int64Ptr(50)},
+			"tags": {
+				Type:     []string{"array"},
+				MaxItems: int64Ptr(10),
+				Items:    &base.DynamicValue[*base.SchemaProxy, bool]{A: base.CreateSchemaProxy(&base.Schema{Type: []string{"string"}, MaxLength: int64Ptr(20)})},
+			},
+		})
+	}
+
+	tests := []struct {
+		name     string
+		schema   *base.Schema
+		expected JSONLimits
+	}{
+		{
+			name:     "closed object",
+			schema:   pet(&closed),
+			expected: JSONLimits{ContainerDepth: 2, ArrayElementCount: 10, ObjectEntryCount: 2, ObjectEntryNameLength: 4, StringValueLength: 50},
+		},
+		{
+			name:     "object without additionalProperties",
+			schema:   pet(nil),
+			expected: JSONLimits{ArrayElementCount: 10, StringValueLength: 50},
+		},
+		{
+			name:     "object with additionalProperties true",
+			schema:   pet(&open),
+			expected: JSONLimits{ArrayElementCount: 10, StringValueLength: 50},
+		},
+		{
+			name:     "free-form object",
+			schema:   &base.Schema{Type: []string{"object"}},
+			expected: JSONLimits{},
+		},
+		{
+			name:     "any value",
+			schema:   &base.Schema{},
+			expected: JSONLimits{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limits := &schemaLimits{}
+			limits.walk(test.schema, 0, nil)
+
+			actual := JSONLimits{
+				ContainerDepth:        limitOrZero(limits.containerDepth),
+				ArrayElementCount:     limitOrZero(limits.arrayElementCount),
+				ObjectEntryCount:      limitOrZero(limits.objectEntryCount),
+				ObjectEntryNameLength: limitOrZero(limits.objectEntryNameLength),
+				StringValueLength:     limitOrZero(limits.stringValueLength),
+			}
+
+			if actual != test.expected {
+				t.Fatalf("expected %+v, got %+v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func limitOrZero(limit schemaLimit) int {
+	if limit.unbounded {
+		return 0
+	}
+	return limit.max
+}
+
+func TestAddThreatProtectionSkipsUnboundedPolicies(t *testing.T) {
+	apiProxy := &v1.APIProxy{}
+	flow := &v1.ConditionalFlow{
+		Name: "createPet",
+		Verb: "POST",
+		RequestBody: &v1.RequestBody{Content: []*v1.MediaType{
+			{ContentType: "application/json", Schema: &base.Schema{Type: []string{"object"}}},
+		}},
+	}
+	proxyEndpoint := &v1.ProxyEndpoint{Flows: []*v1.ConditionalFlow{flow}}
+
+	options := NewOptions()
+	options.ThreatProtection = true
+	if err := AddThreatProtection(apiProxy, proxyEndpoint, options); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(apiProxy.Policies) != 0 || len(flow.Request) != 0 {
+		t.Fatalf("expected no threat protection policy for a schema without limits, got %d policies", len(apiProxy.Policies))
+	}
+}
+
+func TestContentTypeCondition(t *testing.T) {
+	condition := contentTypeCondition([]string{"application/json", "application/problem+json; charset=utf-8"})
+	regex := regexp.MustCompile(`JavaRegex "(.*)"$`).FindStringSubmatch(condition)[1]
+	matcher := regexp.MustCompile("^(?:" + regex + ")$")
+
+	tests := map[string]bool{
+		"application/json":                    true,
+		"Application/JSON":                    true,
+		"application/json; charset=utf-8":     true,
+		"application/problem+json":            true,
+		"application/jsonp":                   false,
+		"text/plain":                          false,
+		"application/xml; x=application/json": false,
+	}
+
+	for contentType, expected := range tests {
+		if matcher.MatchString(contentType) != expected {
+			t.Errorf("expected %q to match %v", contentType, expected)
+		}
+	}
+}
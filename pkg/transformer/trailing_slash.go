@@ -0,0 +1,75 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformer
+
+import (
+	"fmt"
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"strings"
+)
+
+// AddTrailingSlashRedirect adds a flow (ahead of all other flows) that redirects requests
+// with a trailing slash to the same path without it, keeping the query string.
+// Paths declared with a trailing slash in the spec are not redirected, so that their operations can still be reached.
+func AddTrailingSlashRedirect(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, options *Options) error {
+	if options.TrailingSlash != TrailingSlashRedirect {
+		return nil
+	}
+
+	location := `{proxy.basepath}{replaceFirst(proxy.pathsuffix,"/+$","")}`
+
+	redirectPolicy := NewRaiseFaultPolicy("RF-TrailingSlashRedirect", "308", "Permanent Redirect")
+	redirectPolicy.RaiseFault.FaultResponse.Set.Headers = []*Header{NewHeader("Location", location)}
+
+	redirectQueryPolicy := NewRaiseFaultPolicy("RF-TrailingSlashRedirectQuery", "308", "Permanent Redirect")
+	redirectQueryPolicy.RaiseFault.FaultResponse.Set.Headers = []*Header{NewHeader("Location", location+"?{request.querystring}")}
+
+	var err error
+	for _, policy := range []any{redirectPolicy, redirectQueryPolicy} {
+		if _, err = AddPolicy(apiProxy, policy); err != nil {
+			return err
+		}
+	}
+
+	redirectCondition := `proxy.pathsuffix JavaRegex ".+/"`
+	var declaredConditions []string
+	paths, flowsByPath := GroupFlowsByPath(operationFlows(proxyEndpoint.Flows))
+	for _, path := range paths {
+		if strings.HasSuffix(path, "/") {
+			declaredConditions = append(declaredConditions, fmt.Sprintf("(%s)", BuildPathCondition(path, flowsByPath[path][0].Parameters, options)))
+		}
+	}
+
+	if len(declaredConditions) > 0 {
+		redirectCondition = fmt.Sprintf("(%s) and not (%s)", redirectCondition, strings.Join(declaredConditions, " or "))
+	}
+
+	noQueryCondition := `(request.querystring = null) or (request.querystring = "")`
+
+	redirectFlow := &v1.ConditionalFlow{
+		Name:        "trailing-slash-redirect",
+		Description: "Redirects requests with a trailing slash",
+		Condition:   redirectCondition,
+		Request: []*v1.Step{
+			v1.NewStep(redirectPolicy.RaiseFault.Name, noQueryCondition),
+			v1.NewStep(redirectQueryPolicy.RaiseFault.Name, "not ("+noQueryCondition+")"),
+		},
+		Response:   []*v1.Step{},
+		Extensions: map[string]*v1.Extension{},
+	}
+
+	proxyEndpoint.Flows = append([]*v1.ConditionalFlow{redirectFlow}, proxyEndpoint.Flows...)
+	return nil
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/pb33f/libopenapi/orderedmap"
 	"gopkg.in/yaml.v3"
 	"regexp"
+	"strings"
 )
 
 func GetExtensions(source *orderedmap.Map[string, *yaml.Node]) map[string]*v1.Extension {
@@ -63,7 +64,46 @@ func SetupRouteRules(apiProxy *v1.APIProxy, proxyEndpoint *v1.ProxyEndpoint, tar
 	apiProxy.Resources = []*v1.Resource{}
 }
 
+// NormalizeBasePath makes sure the base path starts with a slash, and has no trailing slashes
+func NormalizeBasePath(basePath string) string {
+	basePath = strings.TrimRight(strings.TrimSpace(basePath), "/")
+	if !strings.HasPrefix(basePath, "/") {
+		basePath = "/" + basePath
+	}
+	return basePath
+}
+
 func ToApigeePath(oasPath string) string {
 	re := regexp.MustCompile(`{[^}]*}`)
 	return re.ReplaceAllString(oasPath, "*")
 }
+
+// MergeParameters combines path-level and operation-level parameters.
+// Operation-level parameters override path-level parameters with the same name and location.
+func MergeParameters(pathParams []*v1.Parameter, operationParams []*v1.Parameter) []*v1.Parameter {
+	result := []*v1.Parameter{}
+	for _, pathParam := range pathParams {
+		overridden := false
+		for _, operationParam := range operationParams {
+			if operationParam.Name == pathParam.Name && operationParam.In == pathParam.In {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			result = append(result, pathParam)
+		}
+	}
+
+	return append(result, operationParams...)
+}
+
+// AppendUnique appends the value to the list, unless the list already contains it
+func AppendUnique(list []string, value string) []string {
+	for _, item := range list {
+		if item == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
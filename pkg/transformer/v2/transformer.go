@@ -16,17 +16,19 @@ package v2
 
 import (
 	"fmt"
+	"github.com/go-errors/errors"
 	"github.com/gosimple/slug"
 	v1 "github.com/micovery/spec2proxy/pkg/apigee/v1"
 	"github.com/micovery/spec2proxy/pkg/transformer"
 	"github.com/pb33f/libopenapi"
+	"github.com/pb33f/libopenapi/datamodel/high/base"
 	v2high "github.com/pb33f/libopenapi/datamodel/high/v2"
-	"net/url"
+	"github.com/pb33f/libopenapi/orderedmap"
 	"strings"
 	"time"
 )
 
-func Transform(specModel *libopenapi.DocumentModel[v2high.Swagger]) (*v1.APIProxy, error) {
+func Transform(specModel *libopenapi.DocumentModel[v2high.Swagger], options *transformer.Options) (*v1.APIProxy, error) {
 	var err error
 	var targetEndpoint *v1.TargetEndpoint
 	var proxyEndpoint *v1.ProxyEndpoint
@@ -41,25 +43,50 @@ func Transform(specModel *libopenapi.DocumentModel[v2high.Swagger]) (*v1.APIProx
 	transformer.AppendExtensions(apiProxy.Extensions, specModel.Model.Info.Extensions)
 	apiProxy.CreatedAt = now
 	apiProxy.LastModified = now
+	apiProxy.SecuritySchemes = buildSecuritySchemes(specModel.Model.SecurityDefinitions)
 
 	//build proxy endpoint
-	if proxyEndpoint, err = buildProxyEndpoint(specModel); err != nil {
+	if proxyEndpoint, err = buildProxyEndpoint(specModel, options); err != nil {
 		return nil, err
 	}
 
-	//build target endpoint
-	if targetEndpoint, err = buildTargetEndpoint(specModel); err != nil {
+	if options.Mock {
+		//mock proxies respond with the spec examples, without target
+		transformer.SetupMockRouteRules(&apiProxy, proxyEndpoint)
+	} else {
+		//build target endpoint
+		if targetEndpoint, err = buildTargetEndpoint(specModel, options); err != nil {
+			return nil, err
+		}
+
+		//link proxy endpoint to target endpoint with route rule
+		transformer.SetupRouteRules(&apiProxy, proxyEndpoint, targetEndpoint)
+	}
+
+	//move operations into their own proxy endpoints
+	if err = transformer.SplitProxyEndpoints(&apiProxy, options); err != nil {
 		return nil, err
 	}
 
-	//link proxy endpoint to target endpoint with route rule
-	//link proxy endpoint to target endpoint with route rule
-	transformer.SetupRouteRules(&apiProxy, proxyEndpoint, targetEndpoint)
+	//the OASValidation policy only supports OpenAPI 3 specs
+	var needsOASResource bool
+	if needsOASResource, err = transformer.NeedsOASResource(&apiProxy, options); err != nil {
+		return nil, err
+	}
+
+	if needsOASResource {
+		return nil, errors.Errorf("bundling the spec for OASValidation requires an OpenAPI 3 spec")
+	}
+
+	//generate policies derived from the spec
+	if err = transformer.GeneratePolicies(&apiProxy, options); err != nil {
+		return nil, err
+	}
 
 	return &apiProxy, nil
 }
 
-func buildTargetEndpoint(specModel *libopenapi.DocumentModel[v2high.Swagger]) (*v1.TargetEndpoint, error) {
+func buildTargetEndpoint(specModel *libopenapi.DocumentModel[v2high.Swagger], options *transformer.Options) (*v1.TargetEndpoint, error) {
 	var targetEndpoint v1.TargetEndpoint
 	targetEndpoint.Name = "default"
 	targetEndpoint.Flows = []*v1.ConditionalFlow{}
@@ -76,32 +103,28 @@ func buildTargetEndpoint(specModel *libopenapi.DocumentModel[v2high.Swagger]) (*
 	}
 
 	targetEndpoint.HTTPTargetConnection = &v1.HTTPTargetConnection{
-		URL: extractTargetEndpointUrl(specModel),
-		SSLInfo: v1.SSLInfo{
-			Enabled:                true,
-			Enforce:                false,
-			IgnoreValidationErrors: true,
-		},
+		URL:        extractTargetEndpointUrl(specModel),
 		Properties: nil,
 	}
 
-	var parsedUrl *url.URL
 	var err error
-	if parsedUrl, err = url.Parse(targetEndpoint.HTTPTargetConnection.URL); err != nil {
+	extensions := transformer.GetExtensions(specModel.Model.Extensions)
+	if targetEndpoint.HTTPTargetConnection.SSLInfo, err = transformer.BuildSSLInfo(targetEndpoint.HTTPTargetConnection.URL,
+		extensions, options); err != nil {
 		return nil, err
 	}
 
-	if parsedUrl.Scheme == "http" {
-		targetEndpoint.HTTPTargetConnection.SSLInfo.Enabled = false
+	if targetEndpoint.HTTPTargetConnection.Authentication, err = transformer.BuildTargetAuthentication(extensions, options); err != nil {
+		return nil, err
 	}
 
 	return &targetEndpoint, nil
 }
 
-func buildProxyEndpoint(specModel *libopenapi.DocumentModel[v2high.Swagger]) (*v1.ProxyEndpoint, error) {
+func buildProxyEndpoint(specModel *libopenapi.DocumentModel[v2high.Swagger], options *transformer.Options) (*v1.ProxyEndpoint, error) {
 	var proxyEndpoint v1.ProxyEndpoint
 
-	proxyEndpoint.BasePath = specModel.Model.BasePath
+	proxyEndpoint.BasePath = transformer.NormalizeBasePath(specModel.Model.BasePath)
 
 	proxyEndpoint.Name = "default"
 	proxyEndpoint.PreFlow = &v1.UnconditionalFlow{
@@ -121,7 +144,7 @@ func buildProxyEndpoint(specModel *libopenapi.DocumentModel[v2high.Swagger]) (*v
 	proxyEndpoint.SecurityRequirement = specModel.Model.Security
 	proxyEndpoint.Extensions = transformer.GetExtensions(specModel.Model.Paths.Extensions)
 
-	appendConditionalFlows(&proxyEndpoint, specModel.Model.Paths)
+	appendConditionalFlows(&proxyEndpoint, specModel.Model.Paths, specModel.Model.Consumes, specModel.Model.Produces, options)
 
 	return &proxyEndpoint, nil
 }
@@ -143,11 +166,10 @@ func extractTargetEndpointUrl(specModel *libopenapi.DocumentModel[v2high.Swagger
 	return url
 }
 
-func appendConditionalFlows(endpoint *v1.ProxyEndpoint, paths *v2high.Paths) {
+func appendConditionalFlows(endpoint *v1.ProxyEndpoint, paths *v2high.Paths, consumes []string, produces []string, options *transformer.Options) {
 	endpoint.Flows = []*v1.ConditionalFlow{}
 
 	for path := paths.PathItems.First(); path != nil; path = path.Next() {
-		pathSegment := transformer.ToApigeePath(path.Key())
 		pathInfo := path.Value()
 		operations := pathInfo.GetOperations()
 
@@ -158,15 +180,190 @@ func appendConditionalFlows(endpoint *v1.ProxyEndpoint, paths *v2high.Paths) {
 			conditionalFlow := &v1.ConditionalFlow{
 				Name:                operationInfo.OperationId,
 				Description:         operationInfo.Description,
-				Condition:           fmt.Sprintf("(proxy.pathsuffix MatchesPath \"%s\") and (request.verb = \"%s\")", pathSegment, strings.ToUpper(operationKey)),
 				Request:             []*v1.Step{},
 				Response:            []*v1.Step{},
 				Extensions:          transformer.GetExtensions(pathInfo.Extensions),
 				SecurityRequirement: operationInfo.Security,
+				Path:                path.Key(),
+				Verb:                strings.ToUpper(operationKey),
+				Tags:                operationInfo.Tags,
+				Deprecated:          operationInfo.Deprecated,
+				Parameters:          transformer.MergeParameters(buildParameters(pathInfo.Parameters), buildParameters(operationInfo.Parameters)),
+				RequestBody:         buildRequestBody(pathInfo.Parameters, operationInfo, consumes),
+				Responses:           buildResponses(operationInfo, produces),
 			}
+			conditionalFlow.Condition = transformer.BuildFlowCondition(conditionalFlow, options)
 
 			transformer.AppendExtensions(conditionalFlow.Extensions, operationInfo.Extensions)
 			endpoint.Flows = append(endpoint.Flows, conditionalFlow)
 		}
 	}
 }
+
+func buildParameters(parameters []*v2high.Parameter) []*v1.Parameter {
+	result := []*v1.Parameter{}
+	for _, parameter := range parameters {
+		//body and form parameters are not part of the request path, query or headers
+		if parameter.In == "body" || parameter.In == "formData" {
+			continue
+		}
+
+		param := &v1.Parameter{
+			Name:       parameter.Name,
+			In:         parameter.In,
+			Required:   parameter.Required != nil && *parameter.Required,
+			Extensions: transformer.GetExtensions(parameter.Extensions),
+			Schema: &base.Schema{
+				Format:    parameter.Format,
+				Pattern:   parameter.Pattern,
+				Enum:      parameter.Enum,
+				Default:   parameter.Default,
+				MaxLength: toInt64(parameter.MaxLength),
+				MinLength: toInt64(parameter.MinLength),
+				Maximum:   toFloat64(parameter.Maximum),
+				Minimum:   toFloat64(parameter.Minimum),
+			},
+		}
+		if parameter.Type != "" {
+			param.Schema.Type = []string{parameter.Type}
+		}
+		result = append(result, param)
+	}
+	return result
+}
+
+// buildRequestBody returns the request body from the body parameter, with one media type for each of the consumed content types
+func buildRequestBody(pathParameters []*v2high.Parameter, operation *v2high.Operation, consumes []string) *v1.RequestBody {
+	var bodyParameter *v2high.Parameter
+	for _, parameter := range append(pathParameters, operation.Parameters...) {
+		if parameter.In == "body" {
+			bodyParameter = parameter
+		}
+	}
+
+	if bodyParameter == nil {
+		return nil
+	}
+
+	if len(operation.Consumes) > 0 {
+		consumes = operation.Consumes
+	}
+	if len(consumes) == 0 {
+		consumes = []string{"application/json"}
+	}
+
+	result := &v1.RequestBody{
+		Required: bodyParameter.Required != nil && *bodyParameter.Required,
+		Content:  []*v1.MediaType{},
+	}
+	for _, contentType := range consumes {
+		mediaType := &v1.MediaType{ContentType: contentType}
+		if bodyParameter.Schema != nil {
+			mediaType.Schema = bodyParameter.Schema.Schema()
+		}
+		result.Content = append(result.Content, mediaType)
+	}
+	return result
+}
+
+func toInt64(value *int) *int64 {
+	if value == nil {
+		return nil
+	}
+	result := int64(*value)
+	return &result
+}
+
+func toFloat64(value *int) *float64 {
+	if value == nil {
+		return nil
+	}
+	result := float64(*value)
+	return &result
+}
+
+func buildSecuritySchemes(definitions *v2high.SecurityDefinitions) map[string]*v1.SecurityScheme {
+	result := make(map[string]*v1.SecurityScheme)
+	if definitions == nil || definitions.Definitions == nil {
+		return result
+	}
+
+	for elem := definitions.Definitions.First(); elem != nil; elem = elem.Next() {
+		securityScheme := elem.Value()
+		scheme := &v1.SecurityScheme{
+			Name:        elem.Key(),
+			Type:        securityScheme.Type,
+			Description: securityScheme.Description,
+			In:          securityScheme.In,
+			ParamName:   securityScheme.Name,
+			Scopes:      []string{},
+			Extensions:  transformer.GetExtensions(securityScheme.Extensions),
+		}
+
+		//OAS2 basic schemes are the equivalent of OAS3 http schemes with basic authentication
+		if securityScheme.Type == "basic" {
+			scheme.Type = "http"
+			scheme.Scheme = "basic"
+		}
+
+		if securityScheme.Scopes != nil && securityScheme.Scopes.Values != nil {
+			for scope := securityScheme.Scopes.Values.First(); scope != nil; scope = scope.Next() {
+				scheme.Scopes = append(scheme.Scopes, scope.Key())
+			}
+		}
+
+		result[scheme.Name] = scheme
+	}
+
+	return result
+}
+
+// buildResponses returns the responses of the operation, with one media type for each of the produced content types.
+// The default response goes last.
+func buildResponses(operation *v2high.Operation, produces []string) []*v1.Response {
+	result := []*v1.Response{}
+	if operation.Responses == nil {
+		return result
+	}
+
+	if len(operation.Produces) > 0 {
+		produces = operation.Produces
+	}
+	if len(produces) == 0 {
+		produces = []string{"application/json"}
+	}
+
+	buildResponse := func(statusCode string, response *v2high.Response) *v1.Response {
+		built := &v1.Response{
+			StatusCode:  statusCode,
+			Description: response.Description,
+			Content:     []*v1.MediaType{},
+		}
+
+		//responses without schema and examples have no body
+		if response.Schema == nil && (response.Examples == nil || orderedmap.Len(response.Examples.Values) == 0) {
+			return built
+		}
+
+		for _, contentType := range produces {
+			mediaType := &v1.MediaType{ContentType: contentType}
+			if response.Schema != nil {
+				mediaType.Schema = response.Schema.Schema()
+			}
+			if response.Examples != nil && response.Examples.Values != nil {
+				mediaType.Example = response.Examples.Values.GetOrZero(contentType)
+			}
+			built.Content = append(built.Content, mediaType)
+		}
+		return built
+	}
+
+	for elem := operation.Responses.Codes.First(); elem != nil; elem = elem.Next() {
+		result = append(result, buildResponse(elem.Key(), elem.Value()))
+	}
+
+	if operation.Responses.Default != nil {
+		result = append(result, buildResponse("default", operation.Responses.Default))
+	}
+	return result
+}
@@ -15,18 +15,19 @@
 package v3
 
 import (
-	"fmt"
+	"github.com/go-errors/errors"
 	"github.com/gosimple/slug"
 	"github.com/micovery/spec2proxy/pkg/apigee/v1"
 	"github.com/micovery/spec2proxy/pkg/transformer"
 	"github.com/pb33f/libopenapi"
 	v3high "github.com/pb33f/libopenapi/datamodel/high/v3"
+	"github.com/pb33f/libopenapi/orderedmap"
 	"net/url"
 	"strings"
 	"time"
 )
 
-func Transform(specModel *libopenapi.DocumentModel[v3high.Document]) (*v1.APIProxy, error) {
+func Transform(specModel *libopenapi.DocumentModel[v3high.Document], options *transformer.Options) (*v1.APIProxy, error) {
 	var err error
 	var targetEndpoint *v1.TargetEndpoint
 	var proxyEndpoint *v1.ProxyEndpoint
@@ -41,24 +42,62 @@ func Transform(specModel *libopenapi.DocumentModel[v3high.Document]) (*v1.APIPro
 	transformer.AppendExtensions(apiProxy.Extensions, specModel.Model.Info.Extensions)
 	apiProxy.CreatedAt = now
 	apiProxy.LastModified = now
+	apiProxy.SecuritySchemes = buildSecuritySchemes(specModel.Model.Components)
 
 	//build proxy endpoint
-	if proxyEndpoint, err = buildProxyEndpoint(specModel); err != nil {
+	if proxyEndpoint, err = buildProxyEndpoint(specModel, options); err != nil {
 		return nil, err
 	}
 
-	//build target endpoint
-	if targetEndpoint, err = buildTargetEndpoint(specModel); err != nil {
+	if options.Mock {
+		//mock proxies respond with the spec examples, without target
+		transformer.SetupMockRouteRules(&apiProxy, proxyEndpoint)
+	} else {
+		//build target endpoint
+		if targetEndpoint, err = buildTargetEndpoint(specModel, options); err != nil {
+			return nil, err
+		}
+
+		//link proxy endpoint to target endpoint with route rule
+		transformer.SetupRouteRules(&apiProxy, proxyEndpoint, targetEndpoint)
+	}
+
+	//move operations into their own proxy endpoints
+	if err = transformer.SplitProxyEndpoints(&apiProxy, options); err != nil {
+		return nil, err
+	}
+
+	//bundle the spec with the proxy
+	var needsOASResource bool
+	if needsOASResource, err = transformer.NeedsOASResource(&apiProxy, options); err != nil {
 		return nil, err
 	}
 
-	//link proxy endpoint to target endpoint with route rule
-	transformer.SetupRouteRules(&apiProxy, proxyEndpoint, targetEndpoint)
+	//the OASValidation policy only supports OpenAPI 3.0 specs
+	if needsOASResource && !strings.HasPrefix(specModel.Model.Version, "3.0") {
+		return nil, errors.Errorf("bundling the spec for OASValidation requires an OpenAPI 3.0 spec, got '%s'", specModel.Model.Version)
+	}
+
+	if needsOASResource {
+		var rendered []byte
+		if rendered, err = specModel.Model.RenderInline(); err != nil {
+			return nil, errors.New(err)
+		}
+
+		if err = transformer.AddOASResource(&apiProxy, rendered); err != nil {
+			return nil, err
+		}
+	}
+
+	//generate policies derived from the spec
+	if err = transformer.GeneratePolicies(&apiProxy, options); err != nil {
+		return nil, err
+	}
 
 	return &apiProxy, nil
 }
 
-func buildTargetEndpoint(specModel *libopenapi.DocumentModel[v3high.Document]) (*v1.TargetEndpoint, error) {
+func buildTargetEndpoint(specModel *libopenapi.DocumentModel[v3high.Document], options *transformer.Options) (*v1.TargetEndpoint, error) {
 	var targetEndpoint v1.TargetEndpoint
 	targetEndpoint.Name = "default"
 	targetEndpoint.Flows = []*v1.ConditionalFlow{}
@@ -75,31 +114,28 @@ func buildTargetEndpoint(specModel *libopenapi.DocumentModel[v3high.Document]) (
 	}
 
 	targetEndpoint.HTTPTargetConnection = &v1.HTTPTargetConnection{
-		URL: extractTargetEndpointUrl(specModel),
-		SSLInfo: v1.SSLInfo{
-			Enabled:                true,
-			Enforce:                false,
-			IgnoreValidationErrors: true,
-		},
+		URL:        extractTargetEndpointUrl(specModel),
 		Properties: nil,
 	}
 
-	var parsedUrl *url.URL
 	var err error
-	if parsedUrl, err = url.Parse(targetEndpoint.HTTPTargetConnection.URL); err != nil {
+	extensions := transformer.GetExtensions(specModel.Model.Extensions)
+	if targetEndpoint.HTTPTargetConnection.SSLInfo, err = transformer.BuildSSLInfo(targetEndpoint.HTTPTargetConnection.URL,
+		extensions, options); err != nil {
 		return nil, err
 	}
-	if parsedUrl.Scheme == "http" {
-		targetEndpoint.HTTPTargetConnection.SSLInfo.Enabled = false
+
+	if targetEndpoint.HTTPTargetConnection.Authentication, err = transformer.BuildTargetAuthentication(extensions, options); err != nil {
+		return nil, err
 	}
 
 	return &targetEndpoint, nil
 }
 
-func buildProxyEndpoint(specModel *libopenapi.DocumentModel[v3high.Document]) (*v1.ProxyEndpoint, error) {
+func buildProxyEndpoint(specModel *libopenapi.DocumentModel[v3high.Document], options *transformer.Options) (*v1.ProxyEndpoint, error) {
 	var proxyEndpoint v1.ProxyEndpoint
 
-	proxyEndpoint.BasePath = extractBasePath(specModel)
+	proxyEndpoint.BasePath = transformer.NormalizeBasePath(extractBasePath(specModel))
 
 	proxyEndpoint.Name = "default"
 	proxyEndpoint.PreFlow = &v1.UnconditionalFlow{
@@ -119,7 +155,7 @@ func buildProxyEndpoint(specModel *libopenapi.DocumentModel[v3high.Document]) (*
 	proxyEndpoint.SecurityRequirement = specModel.Model.Security
 	proxyEndpoint.Extensions = transformer.GetExtensions(specModel.Model.Paths.Extensions)
 
-	appendConditionalFlows(&proxyEndpoint, specModel.Model.Paths)
+	appendConditionalFlows(&proxyEndpoint, specModel.Model.Paths, options)
 
 	return &proxyEndpoint, nil
 }
@@ -155,11 +191,10 @@ func extractBasePath(specModel *libopenapi.DocumentModel[v3high.Document]) strin
 	return url.Path
 }
 
-func appendConditionalFlows(endpoint *v1.ProxyEndpoint, paths *v3high.Paths) {
+func appendConditionalFlows(endpoint *v1.ProxyEndpoint, paths *v3high.Paths, options *transformer.Options) {
 	endpoint.Flows = []*v1.ConditionalFlow{}
 
 	for path := paths.PathItems.First(); path != nil; path = path.Next() {
-		pathSegment := transformer.ToApigeePath(path.Key())
 		pathInfo := path.Value()
 		operations := pathInfo.GetOperations()
 
@@ -170,15 +205,138 @@ func appendConditionalFlows(endpoint *v1.ProxyEndpoint, paths *v3high.Paths) {
 			conditionalFlow := &v1.ConditionalFlow{
 				Name:                operationInfo.OperationId,
 				Description:         operationInfo.Description,
-				Condition:           fmt.Sprintf("(proxy.pathsuffix MatchesPath \"%s\") and (request.verb = \"%s\")", pathSegment, strings.ToUpper(operationKey)),
 				Request:             []*v1.Step{},
 				Response:            []*v1.Step{},
 				Extensions:          transformer.GetExtensions(pathInfo.Extensions),
 				SecurityRequirement: operationInfo.Security,
+				Path:                path.Key(),
+				Verb:                strings.ToUpper(operationKey),
+				Tags:                operationInfo.Tags,
+				Deprecated:          operationInfo.Deprecated != nil && *operationInfo.Deprecated,
+				Parameters:          transformer.MergeParameters(buildParameters(pathInfo.Parameters), buildParameters(operationInfo.Parameters)),
+				RequestBody:         buildRequestBody(operationInfo.RequestBody),
+				Responses:           buildResponses(operationInfo.Responses),
 			}
+			conditionalFlow.Condition = transformer.BuildFlowCondition(conditionalFlow, options)
 
 			transformer.AppendExtensions(conditionalFlow.Extensions, operationInfo.Extensions)
 			endpoint.Flows = append(endpoint.Flows, conditionalFlow)
 		}
 	}
 }
+
+func buildParameters(parameters []*v3high.Parameter) []*v1.Parameter {
+	result := []*v1.Parameter{}
+	for _, parameter := range parameters {
+		param := &v1.Parameter{
+			Name:          parameter.Name,
+			In:            parameter.In,
+			Required:      parameter.Required != nil && *parameter.Required,
+			AllowReserved: parameter.AllowReserved,
+			Extensions:    transformer.GetExtensions(parameter.Extensions),
+		}
+		if parameter.Schema != nil {
+			param.Schema = parameter.Schema.Schema()
+		}
+		result = append(result, param)
+	}
+	return result
+}
+
+func buildRequestBody(requestBody *v3high.RequestBody) *v1.RequestBody {
+	if requestBody == nil {
+		return nil
+	}
+
+	return &v1.RequestBody{
+		Required: requestBody.Required != nil && *requestBody.Required,
+		Content:  buildContent(requestBody.Content),
+	}
+}
+
+func buildContent(content *orderedmap.Map[string, *v3high.MediaType]) []*v1.MediaType {
+	result := []*v1.MediaType{}
+	for elem := content.First(); elem != nil; elem = elem.Next() {
+		mediaType := &v1.MediaType{
+			ContentType: elem.Key(),
+			Example:     elem.Value().Example,
+		}
+		if elem.Value().Schema != nil {
+			mediaType.Schema = elem.Value().Schema.Schema()
+		}
+		for example := elem.Value().Examples.First(); example != nil; example = example.Next() {
+			mediaType.Examples = append(mediaType.Examples, &v1.Example{Name: example.Key(), Value: example.Value().Value})
+		}
+		result = append(result, mediaType)
+	}
+	return result
+}
+
+// buildResponses returns the responses of the operation, with the default response last
+func buildResponses(responses *v3high.Responses) []*v1.Response {
+	result := []*v1.Response{}
+	if responses == nil {
+		return result
+	}
+
+	for elem := responses.Codes.First(); elem != nil; elem = elem.Next() {
+		result = append(result, &v1.Response{
+			StatusCode:  elem.Key(),
+			Description: elem.Value().Description,
+			Content:     buildContent(elem.Value().Content),
+		})
+	}
+
+	if responses.Default != nil {
+		result = append(result, &v1.Response{
+			StatusCode:  "default",
+			Description: responses.Default.Description,
+			Content:     buildContent(responses.Default.Content),
+		})
+	}
+	return result
+}
+
+func buildSecuritySchemes(components *v3high.Components) map[string]*v1.SecurityScheme {
+	result := make(map[string]*v1.SecurityScheme)
+	if components == nil || components.SecuritySchemes == nil {
+		return result
+	}
+
+	for elem := components.SecuritySchemes.First(); elem != nil; elem = elem.Next() {
+		securityScheme := elem.Value()
+		scheme := &v1.SecurityScheme{
+			Name:             elem.Key(),
+			Type:             securityScheme.Type,
+			Description:      securityScheme.Description,
+			In:               securityScheme.In,
+			ParamName:        securityScheme.Name,
+			Scheme:           strings.ToLower(securityScheme.Scheme),
+			BearerFormat:     securityScheme.BearerFormat,
+			OpenIdConnectUrl: securityScheme.OpenIdConnectUrl,
+			Scopes:           []string{},
+			Extensions:       transformer.GetExtensions(securityScheme.Extensions),
+		}
+
+		if securityScheme.Flows != nil {
+			flows := []*v3high.OAuthFlow{
+				securityScheme.Flows.Implicit,
+				securityScheme.Flows.Password,
+				securityScheme.Flows.ClientCredentials,
+				securityScheme.Flows.AuthorizationCode,
+			}
+			for _, flow := range flows {
+				if flow == nil || flow.Scopes == nil {
+					continue
+				}
+				for scope := flow.Scopes.First(); scope != nil; scope = scope.Next() {
+					scheme.Scopes = transformer.AppendUnique(scheme.Scopes, scope.Key())
+				}
+			}
+		}
+
+		result[scheme.Name] = scheme
+	}
+
+	return result
+}
@@ -0,0 +1,194 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v3
+
+import (
+	"github.com/micovery/spec2proxy/pkg/apigee/v1"
+	"github.com/micovery/spec2proxy/pkg/parser"
+	"github.com/micovery/spec2proxy/pkg/transformer"
+	"github.com/pb33f/libopenapi"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const splitSpec = `
+openapi: 3.0.3
+info:
+  title: split
+  version: "1"
+servers:
+  - url: https://example.com/v1
+x-Apigee-CORS: {}
+paths:
+  /stores:
+    get:
+      operationId: listStores
+      tags: [stores]
+      parameters:
+        - name: sort
+          in: query
+          schema: {type: string, enum: [asc, desc]}
+      responses:
+        "200":
+          description: ok
+          content:
+            application/json:
+              schema: {type: array, items: {type: string}}
+    post:
+      operationId: addStore
+      tags: [stores]
+      requestBody:
+        content:
+          application/json:
+            schema: {type: object}
+      responses:
+        "201": {description: created}
+  /stores/{storeId}:
+    get:
+      operationId: showStore
+      tags: [stores]
+      parameters:
+        - name: storeId
+          in: path
+          required: true
+          schema: {type: string}
+      responses:
+        "200": {description: ok}
+`
+
+func findFlow(apiProxy *v1.APIProxy, name string) *v1.ConditionalFlow {
+	for _, proxyEndpoint := range apiProxy.ProxyEndpoints {
+		for _, flow := range proxyEndpoint.Flows {
+			if flow.Name == name {
+				return flow
+			}
+		}
+	}
+	return nil
+}
+
+func stepNames(steps []*v1.Step) []string {
+	var names []string
+	for _, step := range steps {
+		names = append(names, step.Step.Name)
+	}
+	return names
+}
+
+// TestSplitRootOperations checks that the root operations of a split proxy endpoint (which have an empty relative path)
+// still get the operation-level policies and API product operations
+func TestSplitRootOperations(t *testing.T) {
+	document, err := libopenapi.NewDocument([]byte(splitSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specModel, errs := parser.BuildOAS3Model(document)
+	if len(errs) > 0 {
+		t.Fatal(errs[0])
+	}
+
+	options := transformer.NewOptions()
+	options.SplitByTag = true
+	options.APIProducts = true
+
+	apiProxy, err := Transform(specModel, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = transformer.AddAPIProducts(apiProxy, options); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(apiProxy.ProxyEndpoints) != 1 || apiProxy.ProxyEndpoints[0].BasePath != "/v1/stores" {
+		t.Fatalf("expected a single proxy endpoint with base path /v1/stores")
+	}
+
+	expectedSteps := map[string][]string{
+		"listStores": {"RE-Params-listStores", "RF-HTTP406-listStores"},
+		"addStore":   {"RF-HTTP415-addStore"},
+	}
+
+	for name, expected := range expectedSteps {
+		flow := findFlow(apiProxy, name)
+		if flow == nil {
+			t.Fatalf("flow '%s' not found", name)
+		}
+
+		if flow.Path != "" {
+			t.Fatalf("expected flow '%s' to have an empty relative path, got '%s'", name, flow.Path)
+		}
+
+		names := stepNames(flow.Request)
+		for _, step := range expected {
+			if !slices.Contains(names, step) {
+				t.Errorf("flow '%s' is missing step '%s', got %v", name, step, names)
+			}
+		}
+	}
+
+	if preflightFlow := findFlow(apiProxy, "cors-preflight-v1-stores"); preflightFlow == nil {
+		t.Errorf("the root path of the split proxy endpoint is missing its CORS preflight flow")
+	} else if preflightFlow.Verb != "" {
+		t.Errorf("expected the CORS preflight flow not to be an operation, got verb '%s'", preflightFlow.Verb)
+	}
+
+	if len(apiProxy.APIProducts) != 1 || apiProxy.APIProducts[0].Name != "split-stores" {
+		t.Fatalf("expected a single 'split-stores' API product")
+	}
+
+	var methods []string
+	for _, config := range apiProxy.APIProducts[0].OperationGroup.OperationConfigs {
+		for _, operation := range config.Operations {
+			if operation.Resource == "/" {
+				methods = append(methods, operation.Methods...)
+			}
+		}
+	}
+
+	slices.Sort(methods)
+	if !slices.Equal(methods, []string{"GET", "POST"}) {
+		t.Errorf("expected the root resource of the API product to have GET and POST, got %v", methods)
+	}
+}
+
+func TestOASValidationVersion(t *testing.T) {
+	tests := map[string]bool{
+		"3.0.3": false,
+		"3.1.0": true,
+	}
+
+	for version, expectError := range tests {
+		spec := strings.Replace(splitSpec, "openapi: 3.0.3", "openapi: "+version, 1)
+		document, err := libopenapi.NewDocument([]byte(spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		specModel, errs := parser.BuildOAS3Model(document)
+		if len(errs) > 0 {
+			t.Fatal(errs[0])
+		}
+
+		options := transformer.NewOptions()
+		options.OASValidation = true
+
+		if _, err = Transform(specModel, options); (err != nil) != expectError {
+			t.Errorf("expected an error for OpenAPI %s: %v, got %v", version, expectError, err)
+		}
+	}
+}
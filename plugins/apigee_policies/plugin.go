@@ -133,14 +133,18 @@ func (p *Plugin) ProcessProxyModel(apiProxy *v1.APIProxy) error {
 	if err = UnmarshalExtension("x-Apigee-PostFlow", apiProxy.Extensions, newPostFlow); err != nil {
 		return err
 	}
-	apiProxy.ProxyEndpoints[0].PostFlow = mergeFlows(apiProxy.ProxyEndpoints[0].PostFlow, newPostFlow)
+	for _, proxyEndpoint := range apiProxy.ProxyEndpoints {
+		proxyEndpoint.PostFlow = mergeFlows(proxyEndpoint.PostFlow, newPostFlow)
+	}
 
 	// handle PreFlow
 	newPreFlow := &v1.UnconditionalFlow{}
 	if err = UnmarshalExtension("x-Apigee-PreFlow", apiProxy.Extensions, newPreFlow); err != nil {
 		return err
 	}
-	apiProxy.ProxyEndpoints[0].PreFlow = mergeFlows(apiProxy.ProxyEndpoints[0].PreFlow, newPreFlow)
+	for _, proxyEndpoint := range apiProxy.ProxyEndpoints {
+		proxyEndpoint.PreFlow = mergeFlows(proxyEndpoint.PreFlow, newPreFlow)
+	}
 
 	// handle conditional flows
 	for _, proxyEndpoint := range apiProxy.ProxyEndpoints {
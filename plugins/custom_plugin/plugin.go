@@ -33,17 +33,19 @@ func (p *Plugin) ProcessProxyModel(apiProxy *v1.APIProxy) error {
 		return nil
 	}
 
-	var newFlows []*v1.ConditionalFlow
-
-	for _, flow := range apiProxy.ProxyEndpoints[0].Flows {
-		if isInternalFlow(flow) {
-			fmt.Printf("Removing internal operation: %s\n", flow.Name)
-			continue
+	for _, proxyEndpoint := range apiProxy.ProxyEndpoints {
+		var newFlows []*v1.ConditionalFlow
+
+		for _, flow := range proxyEndpoint.Flows {
+			if isInternalFlow(flow) {
+				fmt.Printf("Removing internal operation: %s\n", flow.Name)
+				continue
+			}
+			newFlows = append(newFlows, flow)
 		}
-		newFlows = append(newFlows, flow)
-	}
 
-	apiProxy.ProxyEndpoints[0].Flows = newFlows
+		proxyEndpoint.Flows = newFlows
+	}
 
 	var err error
 	if err = setupCatchAllFlow(apiProxy); err != nil {
@@ -94,19 +96,6 @@ func setupCatchAllFlow(apiProxy *v1.APIProxy) error {
 	policy.RaiseFault.IgnoreUnresolvedVariables = "true"
 	policy.RaiseFault.FaultResponse.Set.StatusCode = "404"
 
-	//then, lets create a new flow that uses the Raise Fault policy
-	catchAllFlow := &v1.ConditionalFlow{
-		Name:        "catch-all",
-		Description: "Responds HTTP 404",
-		Condition:   "true",
-		Request: []*v1.Step{
-			v1.NewStep(policy.RaiseFault.Name, "true"),
-		},
-		Response:            []*v1.Step{},
-		Extensions:          map[string]*v1.Extension{},
-		SecurityRequirement: nil,
-	}
-
 	var err error
 	policyModel := &v1.Policy{}
 	if err = v1.UnmarshalPolicy(policy, policyModel); err != nil {
@@ -114,7 +103,31 @@ func setupCatchAllFlow(apiProxy *v1.APIProxy) error {
 	}
 
 	apiProxy.Policies = append(apiProxy.Policies, policyModel)
-	apiProxy.ProxyEndpoints[0].Flows = append(apiProxy.ProxyEndpoints[0].Flows, catchAllFlow)
+	for _, proxyEndpoint := range apiProxy.ProxyEndpoints {
+		//reuse the catch-all flow generated by spec2proxy (e.g. for the global security), since it already matches every request
+		var catchAllFlow *v1.ConditionalFlow
+		for _, flow := range proxyEndpoint.Flows {
+			if flow.Name == "catch-all" {
+				catchAllFlow = flow
+			}
+		}
+
+		//otherwise, lets create a new flow that uses the Raise Fault policy
+		if catchAllFlow == nil {
+			catchAllFlow = &v1.ConditionalFlow{
+				Name:                "catch-all",
+				Description:         "Responds HTTP 404",
+				Condition:           "true",
+				Request:             []*v1.Step{},
+				Response:            []*v1.Step{},
+				Extensions:          map[string]*v1.Extension{},
+				SecurityRequirement: nil,
+			}
+			proxyEndpoint.Flows = append(proxyEndpoint.Flows, catchAllFlow)
+		}
+
+		catchAllFlow.Request = append(catchAllFlow.Request, v1.NewStep(policy.RaiseFault.Name, "true"))
+	}
 	return nil
 }
 